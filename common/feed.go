@@ -0,0 +1,51 @@
+package common
+
+import (
+	"encoding/xml"
+	"strings"
+)
+
+// feedDocument matches both RSS (<rss><channel><item><link>)
+// and Atom (<feed><entry><link href="...">) documents
+
+type feedDocument struct {
+	Items []struct {
+		Link string `xml:"link"`
+	} `xml:"channel>item"`
+	Entries []struct {
+		Links []struct {
+			Href string `xml:"href,attr"`
+			Rel  string `xml:"rel,attr"`
+		} `xml:"link"`
+	} `xml:"entry"`
+}
+
+func IsFeedContentType(contentType string) bool {
+	return strings.Contains(contentType, "application/rss+xml") ||
+		strings.Contains(contentType, "application/atom+xml")
+}
+
+func (wp *WebPage) FindAllFeedLinks(maxNumLinks int) ([]string, error) {
+	// Find the links of every item of an RSS
+	// feed or entry of an Atom feed
+	var doc feedDocument
+	err := xml.Unmarshal([]byte(wp.Body), &doc)
+	if err != nil {
+		return nil, err
+	}
+	var links []string
+	for _, item := range doc.Items {
+		links = append(links, strings.TrimSpace(item.Link))
+	}
+	for _, entry := range doc.Entries {
+		for _, link := range entry.Links {
+			if link.Rel == "" || link.Rel == "alternate" {
+				links = append(links, link.Href)
+			}
+		}
+	}
+	if maxNumLinks >= 0 && len(links) > maxNumLinks {
+		links = links[:maxNumLinks]
+	}
+	return links, nil
+}
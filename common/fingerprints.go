@@ -1,24 +1,40 @@
 package common
 
 import (
+	lru "github.com/hashicorp/golang-lru/v2"
 	"hash/fnv"
+	"slices"
 	"strings"
 	"sync"
 )
 
 // Fingerprints struct is a hashmap that maps integers
 // to a list of WebPage pointers. Each integer is a hashed
-// fingerprint.
+// fingerprint. Only the fingerprints of the last maxSize
+// pages inserted are kept, so near-duplicates are found
+// within that window of recent pages. With maxHashes set
+// only that many of a page's fingerprints are kept.
 
 type Fingerprints struct {
-	Mu      sync.Mutex
-	n       int
-	fpSet   map[uint32]map[*WebPage]bool
-	maxSize int
+	Mu        sync.Mutex
+	n         int
+	maxHashes int
+	fpSet     map[uint32]map[*WebPage]bool
+	pages     *lru.Cache[*WebPage, []uint32]
 }
 
 func NewFingerprints(n int, maxSize int) *Fingerprints {
-	return &Fingerprints{n: n, fpSet: make(map[uint32]map[*WebPage]bool), maxSize: maxSize}
+	fp := &Fingerprints{n: n, fpSet: make(map[uint32]map[*WebPage]bool)}
+	fp.pages, _ = lru.NewWithEvict[*WebPage, []uint32](max(maxSize, 1), fp.evict)
+	return fp
+}
+
+func (fp *Fingerprints) SetMaxHashes(maxHashes int) {
+	// Keep only the maxHashes smallest fingerprints of every
+	// page inserted, a bottom-k MinHash sketch which bounds the
+	// memory of large pages while still estimating their
+	// similarity. 0 keeps every fingerprint
+	fp.maxHashes = maxHashes
 }
 
 func (fp *Fingerprints) nGram(text string) []string {
@@ -41,21 +57,57 @@ func (fp *Fingerprints) nGramsToHashes(nGrams []string) []uint32 {
 }
 
 func (fp *Fingerprints) InsertFingerprintsUsingWebpage(wp *WebPage) {
-	nGrams := fp.nGram(wp.Body)
+	nGrams := fp.nGram(wp.DedupBody())
 	hashes := fp.nGramsToHashes(nGrams)
-	fp.Mu.Lock()
+	kept := make([]uint32, 0, len(hashes)/fp.n+1)
 	for _, h := range hashes {
 		if h%uint32(fp.n) == 0 {
-			fp.maintainSizeLimit()
-			if _, exists := fp.fpSet[h]; exists {
-				fp.fpSet[h][wp] = true
-			} else {
-				fp.fpSet[h] = make(map[*WebPage]bool)
-				fp.fpSet[h][wp] = true
+			kept = append(kept, h)
+		}
+	}
+	if fp.maxHashes > 0 {
+		slices.Sort(kept)
+		kept = slices.Compact(kept)
+		kept = kept[:min(len(kept), fp.maxHashes)]
+	}
+	fp.Mu.Lock()
+	defer fp.Mu.Unlock()
+	// Drop the fingerprints of a previous insert of the page
+	fp.pages.Remove(wp)
+	for _, h := range kept {
+		if _, exists := fp.fpSet[h]; exists {
+			fp.fpSet[h][wp] = true
+		} else {
+			fp.fpSet[h] = make(map[*WebPage]bool)
+			fp.fpSet[h][wp] = true
+		}
+	}
+	fp.pages.Add(wp, kept)
+}
+
+func (fp *Fingerprints) FindDuplicate(wp *WebPage, threshold float64) (string, float64) {
+	// Compare the page against every page sharing one of its
+	// fingerprints, returning the first one similar enough
+	fpGlobalSet := fp.GetFingerprintsAsSet()
+	fpWebpageSet := wp.Fingerprints.GetFingerprintsAsSet()
+	fp.Mu.Lock()
+	wp.Fingerprints.Mu.Lock()
+	defer wp.Fingerprints.Mu.Unlock()
+	defer fp.Mu.Unlock()
+	for hash := range fpWebpageSet {
+		if pages, exists := fpGlobalSet[hash]; exists {
+			for page := range pages {
+				// Translations share markup, not content
+				if page.Url == wp.Url || wp.Alternates.Contains(page.Url) || page.Alternates.Contains(wp.Url) {
+					continue
+				}
+				if similarity := wp.Similarity(page); similarity > threshold {
+					return page.Url, similarity
+				}
 			}
 		}
 	}
-	fp.Mu.Unlock()
+	return "", 0
 }
 
 func (fp *Fingerprints) GetFingerprintsAsSet() map[uint32]map[*WebPage]bool {
@@ -68,10 +120,13 @@ func fnvHash(s string) uint32 {
 	return hash.Sum32()
 }
 
-func (fp *Fingerprints) maintainSizeLimit() {
-	// Reset if the size is too large
-	// TODO: Implement better eviction policy
-	if len(fp.fpSet) > fp.maxSize {
-		fp.fpSet = make(map[uint32]map[*WebPage]bool)
+func (fp *Fingerprints) evict(wp *WebPage, hashes []uint32) {
+	// Forget the fingerprints of the oldest page, called
+	// by the LRU with Mu already held by the inserter
+	for _, h := range hashes {
+		delete(fp.fpSet[h], wp)
+		if len(fp.fpSet[h]) == 0 {
+			delete(fp.fpSet, h)
+		}
 	}
 }
@@ -0,0 +1,100 @@
+package common
+
+import (
+	"hash/fnv"
+	"math/bits"
+	"strings"
+	"sync"
+)
+
+// DuplicateDetector remembers the pages inserted into it and
+// finds the ones a new page is a near-duplicate of
+
+type DuplicateDetector interface {
+	InsertFingerprintsUsingWebpage(wp *WebPage)
+	// FindDuplicate returns the URL of an inserted page more than
+	// threshold similar to wp and its similarity, or "" if none is
+	FindDuplicate(wp *WebPage, threshold float64) (string, float64)
+}
+
+// SimHashes stores a single 64-bit SimHash per page, near-duplicates
+// have SimHashes that differ in only a few bits. Similarity is the
+// fraction of bits two SimHashes share, 1 - distance/64 for the
+// Hamming distance between them.
+
+type SimHashes struct {
+	mu      sync.Mutex
+	n       int
+	maxSize int
+	hashes  []simHashEntry
+}
+
+type simHashEntry struct {
+	hash uint64
+	url  string
+}
+
+func NewSimHashes(n int, maxSize int) *SimHashes {
+	return &SimHashes{n: n, maxSize: maxSize, hashes: make([]simHashEntry, 0)}
+}
+
+func (sh *SimHashes) InsertFingerprintsUsingWebpage(wp *WebPage) {
+	hash := SimHash(wp.DedupBody(), sh.n)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	if len(sh.hashes) >= sh.maxSize {
+		// Forget the oldest page
+		sh.hashes = sh.hashes[1:]
+	}
+	sh.hashes = append(sh.hashes, simHashEntry{hash: hash, url: wp.Url})
+}
+
+func (sh *SimHashes) FindDuplicate(wp *WebPage, threshold float64) (string, float64) {
+	hash := SimHash(wp.DedupBody(), sh.n)
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	for _, entry := range sh.hashes {
+		similarity := SimHashSimilarity(bits.OnesCount64(hash ^ entry.hash))
+		if entry.url != wp.Url && !wp.Alternates.Contains(entry.url) && similarity > threshold {
+			return entry.url, similarity
+		}
+	}
+	return "", 0
+}
+
+func SimHashSimilarity(distance int) float64 {
+	return 1 - float64(distance)/64
+}
+
+func SimHashThreshold(maxDistance int) float64 {
+	// The similarity threshold FindDuplicate exceeds exactly
+	// when two SimHashes are at most maxDistance bits apart
+	return SimHashSimilarity(maxDistance) - 0.5/64
+}
+
+func SimHash(text string, n int) uint64 {
+	// Every n-gram of words votes on each of the 64 bits
+	// according to its own hash, the SimHash keeps the
+	// bits most n-grams voted for
+	var votes [64]int
+	words := strings.Fields(strings.ToLower(text))
+	for i := 0; i+n <= len(words); i++ {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(strings.Join(words[i:i+n], " ")))
+		hash := h.Sum64()
+		for bit := 0; bit < 64; bit++ {
+			if hash&(1<<bit) != 0 {
+				votes[bit]++
+			} else {
+				votes[bit]--
+			}
+		}
+	}
+	var simHash uint64
+	for bit := 0; bit < 64; bit++ {
+		if votes[bit] > 0 {
+			simHash |= 1 << bit
+		}
+	}
+	return simHash
+}
@@ -0,0 +1,69 @@
+package common
+
+import (
+	"math/bits"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSimHashThreshold(t *testing.T) {
+	for maxDistance := 0; maxDistance <= 64; maxDistance++ {
+		threshold := SimHashThreshold(maxDistance)
+		if SimHashSimilarity(maxDistance) <= threshold {
+			t.Errorf("distance %d not within SimHashThreshold(%d)", maxDistance, maxDistance)
+		}
+		if maxDistance < 64 && SimHashSimilarity(maxDistance+1) > threshold {
+			t.Errorf("distance %d within SimHashThreshold(%d)", maxDistance+1, maxDistance)
+		}
+	}
+}
+
+func TestSimHashNearDuplicates(t *testing.T) {
+	text := benchmarkText(rand.New(rand.NewSource(1)), 500)
+	edited := strings.Replace(text, " ", " changed ", 1)
+	if distance := bits.OnesCount64(SimHash(text, 3) ^ SimHash(edited, 3)); distance > 8 {
+		t.Errorf("one word edit moved the SimHash %d bits", distance)
+	}
+}
+
+func benchmarkText(r *rand.Rand, words int) string {
+	var sb strings.Builder
+	for i := 0; i < words; i++ {
+		sb.WriteString("w")
+		sb.WriteString(strconv.Itoa(r.Intn(2000)))
+		sb.WriteString(" ")
+	}
+	return sb.String()
+}
+
+func BenchmarkDuplicateDetectors(b *testing.B) {
+	// Insert and look up pages of 500 words against a
+	// window of 1000 recently stored pages
+	const window = 1000
+	r := rand.New(rand.NewSource(1))
+	pages := make([]*WebPage, window+100)
+	for i := range pages {
+		pages[i] = NewWebPage(0, "https://a.com/"+strconv.Itoa(i), "200 OK", benchmarkText(r, 500))
+	}
+	detectors := map[string]func() DuplicateDetector{
+		"shingle": func() DuplicateDetector { return NewFingerprints(3, window) },
+		"simhash": func() DuplicateDetector { return NewSimHashes(3, window) },
+	}
+	for _, name := range []string{"shingle", "simhash"} {
+		b.Run(name, func(b *testing.B) {
+			detector := detectors[name]()
+			for _, wp := range pages[:window] {
+				detector.InsertFingerprintsUsingWebpage(wp)
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				wp := pages[window+i%100]
+				detector.FindDuplicate(wp, 0.9)
+				detector.InsertFingerprintsUsingWebpage(wp)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package common
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// Extensions of stored pages, plain JSON or gzipped JSON
+const (
+	PageExt           = ".json"
+	CompressedPageExt = ".json.gz"
+)
+
+func IsStoredPageName(name string) bool {
+	// Stored pages are named after the hash of their URL,
+	// which tells them apart from other files like the manifest
+	base := strings.TrimSuffix(strings.TrimSuffix(name, CompressedPageExt), PageExt)
+	if base == name || base == "" {
+		return false
+	}
+	for _, r := range base {
+		if !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// storedPageReader closes both the gzip
+// reader and the file underneath it
+
+type storedPageReader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (spr *storedPageReader) Close() error {
+	var err error
+	for _, closer := range spr.closers {
+		if closeErr := closer.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+func OpenStoredPage(path string) (io.ReadCloser, error) {
+	// Open a stored page, transparently decompressing it if
+	// it's gzipped (by its extension or gzip magic bytes)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(f)
+	magic, _ := br.Peek(2)
+	if strings.HasSuffix(path, ".gz") || (len(magic) == 2 && magic[0] == 0x1f && magic[1] == 0x8b) {
+		gr, err := gzip.NewReader(br)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		return &storedPageReader{Reader: gr, closers: []io.Closer{gr, f}}, nil
+	}
+	return &storedPageReader{Reader: br, closers: []io.Closer{f}}, nil
+}
+
+func ReadStoredPage(path string) (*WebPage, error) {
+	r, err := OpenStoredPage(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return DeserializeWebPage(b)
+}
+
+func WalkPages(pageDir string, fn func(wp *WebPage) error) error {
+	// Deserialize every stored page in pageDir and pass it to
+	// fn, stopping at the first error returned. Unreadable pages,
+	// e.g. truncated by a crash, are logged and skipped
+	entries, err := os.ReadDir(pageDir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || !IsStoredPageName(entry.Name()) {
+			continue
+		}
+		path := filepath.Join(pageDir, entry.Name())
+		wp, err := ReadStoredPage(path)
+		if err != nil {
+			slog.Warn("storage - Skipping unreadable page", "path", path, "err", err)
+			continue
+		}
+		err = fn(wp)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
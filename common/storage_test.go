@@ -0,0 +1,32 @@
+package common
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWalkPagesSkipsUnreadablePages(t *testing.T) {
+	dir := t.TempDir()
+	page := NewWebPage(0, "https://a.com/", "200 OK", "<p>a</p>")
+	err := os.WriteFile(filepath.Join(dir, "1"+PageExt), page.Serialize(), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Truncated by a crash mid-write
+	err = os.WriteFile(filepath.Join(dir, "2"+PageExt), page.Serialize()[:10], 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var urls []string
+	err = WalkPages(dir, func(wp *WebPage) error {
+		urls = append(urls, wp.Url)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(urls) != 1 || urls[0] != page.Url {
+		t.Errorf("walked %v, want only %s", urls, page.Url)
+	}
+}
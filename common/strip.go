@@ -0,0 +1,44 @@
+package common
+
+import (
+	"golang.org/x/net/html"
+	"strings"
+)
+
+// Elements whose content is never readable text
+var skippedElements = map[string]bool{"script": true, "style": true, "noscript": true}
+
+func StripHTML(body string) string {
+	// Extract the readable text of an HTML document, dropping
+	// tags, comments and the content of scripts, styles and
+	// noscripts entirely, decoding entities and collapsing
+	// whitespace. Tags separate words, as they would when shown
+	var b strings.Builder
+	z := html.NewTokenizer(strings.NewReader(body))
+	skipping := ""
+	for {
+		switch z.Next() {
+		case html.ErrorToken:
+			// Only io.EOF, reading from a string can't fail
+			return strings.Join(strings.Fields(b.String()), " ")
+		case html.StartTagToken:
+			name, _ := z.TagName()
+			if skipping == "" && skippedElements[string(name)] {
+				skipping = string(name)
+			}
+			b.WriteByte(' ')
+		case html.EndTagToken:
+			name, _ := z.TagName()
+			if string(name) == skipping {
+				skipping = ""
+			}
+			b.WriteByte(' ')
+		case html.SelfClosingTagToken:
+			b.WriteByte(' ')
+		case html.TextToken:
+			if skipping == "" {
+				b.Write(z.Text())
+			}
+		}
+	}
+}
@@ -0,0 +1,35 @@
+package common
+
+import "testing"
+
+func TestStripHTML(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		want string
+	}{
+		{"tags", "<p>Hello <b>world</b></p>", "Hello world"},
+		{"tags separate words", "<p>one</p><p>two</p>two<br>three", "one two two three"},
+		{"script", "<p>before</p><script>var x = '<p>not text</p>';</script><p>after</p>", "before after"},
+		{"script with attributes", `<script type="application/ld+json">{"a": 1}</script>text`, "text"},
+		{"style", "<style>p { color: red; }</style><p>text</p>", "text"},
+		{"noscript", "<noscript><img src=x>enable JavaScript</noscript>text", "text"},
+		{"uppercase script", "<SCRIPT>alert(1)</SCRIPT>text", "text"},
+		{"comment", "<p>a <!-- hidden <b>comment</b> --> b</p>", "a b"},
+		{"comment inside word", "<p>a<!-- hidden -->b</p>", "ab"},
+		{"conditional comment", "<!--[if IE]><p>old browser</p><![endif]-->text", "text"},
+		{"named entities", "<p>Fish &amp; chips &lt;3 &quot;yum&quot;</p>", `Fish & chips <3 "yum"`},
+		{"numeric entities", "<p>caf&#233; &#x2014; na&iuml;ve</p>", "café — naïve"},
+		{"nbsp collapses", "<p>a&nbsp;&nbsp; b</p>", "a b"},
+		{"whitespace", "<p>\n\t a \n\n b </p>", "a b"},
+		{"unclosed script", "text<script>never closed", "text"},
+		{"empty", "", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := StripHTML(test.body); got != test.want {
+				t.Errorf("StripHTML(%q) = %q, want %q", test.body, got, test.want)
+			}
+		})
+	}
+}
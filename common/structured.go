@@ -0,0 +1,67 @@
+package common
+
+import (
+	"bytes"
+	"encoding/json"
+	"html"
+	"log/slog"
+	"regexp"
+	"strings"
+)
+
+// StructuredData is the metadata a page declares about itself,
+// OpenGraph maps meta properties such as og:title or
+// article:published_time to their content and JSONLD holds
+// every well-formed schema.org JSON-LD block
+
+type StructuredData struct {
+	OpenGraph map[string]string `json:"openGraph,omitempty"`
+	JSONLD    []json.RawMessage `json:"jsonLd,omitempty"`
+}
+
+var (
+	metaRe     = regexp.MustCompile(`(?is)<meta\b[^>]*>`)
+	propertyRe = regexp.MustCompile(`(?is)\b(?:property|name)=(?:"([^"]*)"|'([^']*)')`)
+	contentRe  = regexp.MustCompile(`(?is)\bcontent=(?:"([^"]*)"|'([^']*)')`)
+	jsonLDRe   = regexp.MustCompile(`(?is)<script\b[^>]*type=['"]?application/ld\+json['"]?[^>]*>(.*?)</script>`)
+)
+
+func (wp *WebPage) ExtractStructuredData() *StructuredData {
+	// Extract OpenGraph and article meta properties and JSON-LD
+	// from the page's head, returning nil if it has neither.
+	// Malformed JSON-LD is skipped rather than failing the page
+	head := wp.Body
+	if end := strings.Index(strings.ToLower(head), "</head>"); end >= 0 {
+		head = head[:end]
+	}
+	data := &StructuredData{OpenGraph: make(map[string]string)}
+	for _, meta := range metaRe.FindAllString(head, -1) {
+		property := attributeValue(propertyRe, meta)
+		if !strings.HasPrefix(property, "og:") && !strings.HasPrefix(property, "article:") {
+			continue
+		}
+		data.OpenGraph[property] = html.UnescapeString(attributeValue(contentRe, meta))
+	}
+	for _, match := range jsonLDRe.FindAllStringSubmatch(head, -1) {
+		var compacted bytes.Buffer
+		err := json.Compact(&compacted, []byte(strings.TrimSpace(match[1])))
+		if err != nil {
+			slog.Warn("webpage - Skipping malformed JSON-LD", "url", wp.Url, "err", err)
+			continue
+		}
+		data.JSONLD = append(data.JSONLD, compacted.Bytes())
+	}
+	if len(data.OpenGraph) == 0 && len(data.JSONLD) == 0 {
+		return nil
+	}
+	return data
+}
+
+func attributeValue(re *regexp.Regexp, tag string) string {
+	// Value of the double or single quoted attribute matched by re
+	match := re.FindStringSubmatch(tag)
+	if match == nil {
+		return ""
+	}
+	return match[1] + match[2]
+}
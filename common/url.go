@@ -0,0 +1,138 @@
+package common
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// CanonicalizeOptions are normalizations that aren't safe for
+// every site. Paths are case-sensitive on many servers, so
+// lowercasing them is opt-in
+
+type CanonicalizeOptions struct {
+	LowercasePath bool
+}
+
+func Canonicalize(rawUrl string) string {
+	return CanonicalizeWithOptions(rawUrl, CanonicalizeOptions{})
+}
+
+func CanonicalizeWithOptions(rawUrl string, opts CanonicalizeOptions) string {
+	// Reduce a URL to a single canonical form so the same page
+	// reached through different spellings is only stored once:
+	// https scheme, lowercase host without the default port or
+	// a trailing dot, consistent percent-encoding, no trailing slash, no
+	// fragment and query parameters sorted by key
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil || parsedUrl.Host == "" {
+		return rawUrl
+	}
+	parsedUrl.Scheme = strings.ToLower(parsedUrl.Scheme)
+	// Only the default port of the URL's own scheme is implied,
+	// https://a.com:80 is a different server than https://a.com
+	defaultPort := "443"
+	if parsedUrl.Scheme == "http" {
+		parsedUrl.Scheme = "https"
+		defaultPort = "80"
+	}
+	hostname := TrimHostDot(strings.ToLower(parsedUrl.Hostname()))
+	port := parsedUrl.Port()
+	if port == "" || port == defaultPort {
+		parsedUrl.Host = hostname
+	} else {
+		parsedUrl.Host = hostname + ":" + port
+	}
+	escapedPath := normalizePercentEncoding(strings.TrimSuffix(parsedUrl.EscapedPath(), "/"))
+	if opts.LowercasePath {
+		// Normalizing again restores the uppercase hex digits
+		escapedPath = normalizePercentEncoding(strings.ToLower(escapedPath))
+	}
+	path, err := url.PathUnescape(escapedPath)
+	if err != nil {
+		return rawUrl
+	}
+	parsedUrl.Path = path
+	parsedUrl.RawPath = escapedPath
+	parsedUrl.Fragment = ""
+	parsedUrl.RawFragment = ""
+	parsedUrl.RawQuery = sortQuery(parsedUrl.RawQuery)
+	parsedUrl.ForceQuery = false
+	return parsedUrl.String()
+}
+
+func sortQuery(rawQuery string) string {
+	// Sort the query's k=v pairs by key, keeping the order of
+	// repeated keys. Pairs are kept as written rather than decoded
+	// and encoded again, which would escape the / of ?redirect=/x
+	// and turn ?flag into ?flag=
+	pairs := make([]string, 0, strings.Count(rawQuery, "&")+1)
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair != "" {
+			pairs = append(pairs, normalizePercentEncoding(escapeUnsafeBytes(pair)))
+		}
+	}
+	slices.SortStableFunc(pairs, func(a, b string) int {
+		keyA, _, _ := strings.Cut(a, "=")
+		keyB, _, _ := strings.Cut(b, "=")
+		return strings.Compare(keyA, keyB)
+	})
+	return strings.Join(pairs, "&")
+}
+
+func TrimHostDot(host string) string {
+	// example.com. is the fully qualified form of example.com,
+	// only a single dot is stripped since example.com.. is invalid
+	if hostname, port, err := net.SplitHostPort(host); err == nil {
+		return net.JoinHostPort(strings.TrimSuffix(hostname, "."), port)
+	}
+	return strings.TrimSuffix(host, ".")
+}
+
+func normalizePercentEncoding(escaped string) string {
+	// Decode percent-encoded unreserved characters and uppercase
+	// the hex digits of the rest, per RFC 3986 section 6.2.2.
+	// Reserved characters such as %2F stay encoded since
+	// decoding them changes the meaning of the path
+	var b strings.Builder
+	for i := 0; i < len(escaped); i++ {
+		if escaped[i] != '%' || i+2 >= len(escaped) {
+			b.WriteByte(escaped[i])
+			continue
+		}
+		decoded, err := strconv.ParseUint(escaped[i+1:i+3], 16, 8)
+		if err != nil {
+			b.WriteByte(escaped[i])
+			continue
+		}
+		if isUnreserved(byte(decoded)) {
+			b.WriteByte(byte(decoded))
+		} else {
+			b.WriteString(strings.ToUpper(escaped[i : i+3]))
+		}
+		i += 2
+	}
+	return b.String()
+}
+
+func escapeUnsafeBytes(s string) string {
+	// Percent-encode the non-ASCII bytes, spaces and control
+	// characters a raw query may contain, like url.URL does paths
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] <= ' ' || s[i] >= 0x7f {
+			fmt.Fprintf(&b, "%%%02X", s[i])
+		} else {
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+func isUnreserved(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		c == '-' || c == '.' || c == '_' || c == '~'
+}
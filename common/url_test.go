@@ -0,0 +1,87 @@
+package common
+
+import "testing"
+
+func TestCanonicalize(t *testing.T) {
+	tests := []struct {
+		name string
+		url  string
+		want string
+	}{
+		{"http becomes https", "http://a.com/p", "https://a.com/p"},
+		{"lowercase host", "https://A.COM/P", "https://a.com/P"},
+		{"trailing dot", "https://a.com./p", "https://a.com/p"},
+		{"trailing slash", "https://a.com/p/", "https://a.com/p"},
+		{"fragment", "https://a.com/p#top", "https://a.com/p"},
+		{"https default port", "https://a.com:443/p", "https://a.com/p"},
+		{"http default port", "http://a.com:80/p", "https://a.com/p"},
+		{"http port on https", "https://a.com:80/p", "https://a.com:80/p"},
+		{"https port on http", "http://a.com:443/p", "https://a.com:443/p"},
+		{"other port", "https://a.com:8443/p", "https://a.com:8443/p"},
+		{"sorted query", "https://a.com/p?b=2&a=1", "https://a.com/p?a=1&b=2"},
+		{"repeated keys keep order", "https://a.com/p?b=2&a=3&b=1", "https://a.com/p?a=3&b=2&b=1"},
+		{"unescaped query kept", "https://a.com/p?redirect=/x", "https://a.com/p?redirect=/x"},
+		{"query parens and colons kept", "https://a.com/p?u=https://b.com/(x)", "https://a.com/p?u=https://b.com/(x)"},
+		{"valueless parameter", "https://a.com/p?flag", "https://a.com/p?flag"},
+		{"empty query", "https://a.com/p?", "https://a.com/p"},
+		{"empty pairs", "https://a.com/p?a=1&&b=2&", "https://a.com/p?a=1&b=2"},
+		{"query escapes uppercased", "https://a.com/p?q=a%2fb", "https://a.com/p?q=a%2Fb"},
+		{"unreserved query escapes decoded", "https://a.com/p?q=%7Euser", "https://a.com/p?q=~user"},
+		{"unreserved path escapes decoded", "https://a.com/%7Euser", "https://a.com/~user"},
+		{"reserved path escapes kept", "https://a.com/a%2fb", "https://a.com/a%2Fb"},
+		{"non-ASCII path encoded", "https://a.com/café", "https://a.com/caf%C3%A9"},
+		{"encoded path kept", "https://a.com/caf%C3%A9", "https://a.com/caf%C3%A9"},
+		{"lowercase escapes uppercased", "https://a.com/caf%c3%a9", "https://a.com/caf%C3%A9"},
+		{"mixed encoding", "https://a.com/caf%c3%A9/%7e/ü", "https://a.com/caf%C3%A9/~/%C3%BC"},
+		{"non-ASCII query encoded", "https://a.com/p?q=café", "https://a.com/p?q=caf%C3%A9"},
+		{"relative URL unchanged", "/p?b=2&a=1", "/p?b=2&a=1"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := Canonicalize(test.url); got != test.want {
+				t.Errorf("Canonicalize(%q) = %q, want %q", test.url, got, test.want)
+			}
+		})
+	}
+}
+
+func TestCanonicalizeLowercasePath(t *testing.T) {
+	got := CanonicalizeWithOptions("https://a.com/Blog/Post%2f", CanonicalizeOptions{LowercasePath: true})
+	if want := "https://a.com/blog/post%2F"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestCanonicalizeMixedEncodingsAgree(t *testing.T) {
+	spellings := []string{
+		"https://a.com/café",
+		"https://a.com/caf%C3%A9",
+		"https://a.com/caf%c3%a9",
+		"http://A.com/caf%C3%a9/",
+	}
+	want := Canonicalize(spellings[0])
+	for _, spelling := range spellings[1:] {
+		if got := Canonicalize(spelling); got != want {
+			t.Errorf("Canonicalize(%q) = %q, want %q like %q", spelling, got, want, spellings[0])
+		}
+	}
+}
+
+func TestTrimHostDot(t *testing.T) {
+	tests := []struct {
+		host string
+		want string
+	}{
+		{"a.com", "a.com"},
+		{"a.com.", "a.com"},
+		{"a.com.:8443", "a.com:8443"},
+		{"a.com:8443", "a.com:8443"},
+		{"a.com..", "a.com."},
+		{"[::1]:8080", "[::1]:8080"},
+	}
+	for _, test := range tests {
+		if got := TrimHostDot(test.host); got != test.want {
+			t.Errorf("TrimHostDot(%q) = %q, want %q", test.host, got, test.want)
+		}
+	}
+}
@@ -5,16 +5,60 @@ import (
 	"fmt"
 	"html"
 	"log"
+	"net/url"
 	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type WebPage struct {
-	Time         int64  `json:"time"`
-	Url          string `json:"url"`
-	Response     string `json:"response"`
-	Body         string `json:"body"`
-	Fingerprints *Fingerprints
+	Time          int64           `json:"time"`
+	Date          string          `json:"date,omitempty"`
+	Url           string          `json:"url"`
+	Response      string          `json:"response"`
+	Body          string          `json:"body"`
+	ContentLength int64           `json:"contentLength,omitempty"`
+	Redirects     []RedirectHop   `json:"redirects,omitempty"`
+	FetchMillis   int64           `json:"fetchMs,omitempty"`
+	Sequence      int64           `json:"sequence,omitempty"`
+	WordCount     int             `json:"wordCount"`
+	LinkCount     int             `json:"linkCount"`
+	ImageCount    int             `json:"imageCount"`
+	Images        []string        `json:"images,omitempty"`
+	Text          string          `json:"text,omitempty"`
+	Structured    *StructuredData `json:"structured,omitempty"`
+	Alternates    Alternates      `json:"alternates,omitempty"`
+	AmpUrl        string          `json:"ampUrl,omitempty"`
+	AmpOf         string          `json:"ampOf,omitempty"`
+	Fingerprints  *Fingerprints
+	// Body without per-request noise, set by NormalizeBody
+	normalized *string
+}
+
+// Alternates maps the hreflang of each translation of
+// a page, e.g. es or en-gb, to the translation's URL
+
+type Alternates map[string]string
+
+func (a Alternates) Contains(url string) bool {
+	for _, alternate := range a {
+		if alternate == url {
+			return true
+		}
+	}
+	return false
+}
+
+// RedirectHop is a single step of the redirect
+// chain followed before reaching a page, from Url
+// to the absolute URL of its Location header
+
+type RedirectHop struct {
+	Status   int    `json:"status"`
+	Url      string `json:"url"`
+	Location string `json:"location,omitempty"`
 }
 
 func NewWebPage(time int64, url string, response string, body string) *WebPage {
@@ -29,6 +73,34 @@ func NewWebPage(time int64, url string, response string, body string) *WebPage {
 	return wp
 }
 
+func (wp *WebPage) NormalizeBody(noise []*regexp.Regexp) {
+	// Strip per-request noise such as nonces and CSRF tokens from
+	// the body duplicates are detected by, so two requests of the
+	// same page match. The stored Body is left untouched, call
+	// Fingerprint afterwards to fingerprint the normalized body
+	normalized := wp.Body
+	for _, re := range noise {
+		normalized = re.ReplaceAllString(normalized, "")
+	}
+	wp.normalized = &normalized
+}
+
+func (wp *WebPage) Fingerprint(maxHashes int) {
+	// Recompute the page's fingerprints, keeping only
+	// maxHashes of them if it's over 0
+	wp.Fingerprints = NewFingerprints(3, 1000)
+	wp.Fingerprints.SetMaxHashes(maxHashes)
+	wp.Fingerprints.InsertFingerprintsUsingWebpage(wp)
+}
+
+func (wp *WebPage) DedupBody() string {
+	// The body duplicates are detected by
+	if wp.normalized != nil {
+		return *wp.normalized
+	}
+	return wp.Body
+}
+
 func (wp *WebPage) Serialize() []byte {
 	// Serialize WebPage object as JSON byte array
 	b, err := json.Marshal(wp)
@@ -38,6 +110,44 @@ func (wp *WebPage) Serialize() []byte {
 	return b
 }
 
+func DeserializeWebPage(b []byte) (*WebPage, error) {
+	// Deserialize a JSON byte array into a WebPage object,
+	// filling in whichever of the epoch seconds or the RFC3339
+	// date is missing (older pages only store the epoch field)
+	var wp WebPage
+	err := json.Unmarshal(b, &wp)
+	if err != nil {
+		return nil, err
+	}
+	if wp.Time == 0 && wp.Date != "" {
+		t, err := time.Parse(time.RFC3339, wp.Date)
+		if err != nil {
+			return nil, err
+		}
+		wp.Time = t.Unix()
+	}
+	return &wp, nil
+}
+
+func (wp *WebPage) SetRFC3339Date() {
+	// Store the crawl timestamp as a human-readable
+	// and sortable RFC3339 string alongside the epoch
+	wp.Date = time.Unix(wp.Time, 0).UTC().Format(time.RFC3339)
+}
+
+func (wp *WebPage) ComputeStats() {
+	// Count the words of the page's text along with
+	// the number of links and images in its markup.
+	// The text is the stripped Text when already set
+	text := wp.Text
+	if text == "" {
+		text = wp.StripText()
+	}
+	wp.WordCount = len(strings.Fields(text))
+	wp.LinkCount = len(regexp.MustCompile(`(?i)<a\s[^>]*href=`).FindAllStringIndex(wp.Body, -1))
+	wp.ImageCount = len(regexp.MustCompile(`(?i)<img\b`).FindAllStringIndex(wp.Body, -1))
+}
+
 func (wp *WebPage) FindAllAnchorHREFs(maxNumHREF int) []string {
 	// Find all links within HTML markup
 	// (<a href="...">) -> ["..."]
@@ -50,6 +160,168 @@ func (wp *WebPage) FindAllAnchorHREFs(maxNumHREF int) []string {
 	return hrefs
 }
 
+func (wp *WebPage) FindAllImageSrcs(maxNumSrc int) []string {
+	// Find the absolute URLs of all images within HTML markup,
+	// (<img src="..." srcset="... 1x, ... 2x">) -> ["...", ...]
+	// resolved against the page's URL. Each URL is listed once
+	imgRe := regexp.MustCompile(`(?i)<img\b[^>]*>`)
+	srcRe := regexp.MustCompile(`(?i)\ssrc=['"]?([^'" >]+)`)
+	srcsetRe := regexp.MustCompile(`(?i)\ssrcset=(?:"([^"]*)"|'([^']*)'|([^'" >]+))`)
+	base, err := url.Parse(wp.Url)
+	if err != nil {
+		return nil
+	}
+	var srcs []string
+	seen := make(map[string]bool)
+	add := func(src string) {
+		target, err := base.Parse(html.UnescapeString(strings.TrimSpace(src)))
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") {
+			return
+		}
+		target.Fragment = ""
+		if !seen[target.String()] {
+			seen[target.String()] = true
+			srcs = append(srcs, target.String())
+		}
+	}
+	for _, img := range imgRe.FindAllString(wp.Body, -1) {
+		if match := srcRe.FindStringSubmatch(img); match != nil {
+			add(match[1])
+		}
+		if match := srcsetRe.FindStringSubmatch(img); match != nil {
+			// Candidates are a URL optionally followed by
+			// a width or density descriptor, e.g. "a.jpg 2x"
+			for _, candidate := range strings.Split(match[1]+match[2]+match[3], ",") {
+				if fields := strings.Fields(candidate); len(fields) > 0 {
+					add(fields[0])
+				}
+			}
+		}
+		if maxNumSrc >= 0 && len(srcs) >= maxNumSrc {
+			return srcs[:maxNumSrc]
+		}
+	}
+	return srcs
+}
+
+func (wp *WebPage) FindCanonical() string {
+	// Find the URL of <link rel="canonical" href="...">
+	// in the page, or "" if it doesn't declare one
+	linkRe := regexp.MustCompile(`(?i)<link\b[^>]*>`)
+	relRe := regexp.MustCompile(`(?i)\brel=['"]?canonical['"\s>/]`)
+	hrefRe := regexp.MustCompile(`(?i)\bhref=['"]?([^'" >]+)`)
+	for _, link := range linkRe.FindAllString(wp.Body, -1) {
+		if !relRe.MatchString(link) {
+			continue
+		}
+		if match := hrefRe.FindStringSubmatch(link); match != nil {
+			return html.UnescapeString(match[1])
+		}
+	}
+	return ""
+}
+
+func (wp *WebPage) FindAlternates() Alternates {
+	// Find the <link rel="alternate" hreflang="..." href="...">
+	// translations declared in the page's head, keyed by their
+	// lowercased language, or nil if it declares none
+	head := wp.Body
+	if end := strings.Index(strings.ToLower(head), "</head>"); end >= 0 {
+		head = head[:end]
+	}
+	linkRe := regexp.MustCompile(`(?i)<link\b[^>]*>`)
+	relRe := regexp.MustCompile(`(?i)\brel=['"]?alternate['"\s>/]`)
+	hrefLangRe := regexp.MustCompile(`(?i)\bhreflang=['"]?([^'" >]+)`)
+	hrefRe := regexp.MustCompile(`(?i)\bhref=['"]?([^'" >]+)`)
+	base, err := url.Parse(wp.Url)
+	if err != nil {
+		return nil
+	}
+	var alternates Alternates
+	for _, link := range linkRe.FindAllString(head, -1) {
+		lang, href := hrefLangRe.FindStringSubmatch(link), hrefRe.FindStringSubmatch(link)
+		if !relRe.MatchString(link) || lang == nil || href == nil {
+			continue
+		}
+		target, err := base.Parse(html.UnescapeString(href[1]))
+		if err != nil || (target.Scheme != "http" && target.Scheme != "https") {
+			continue
+		}
+		if alternates == nil {
+			alternates = make(Alternates)
+		}
+		alternates[strings.ToLower(lang[1])] = Canonicalize(target.String())
+	}
+	return alternates
+}
+
+func (wp *WebPage) FindAmpHTML() string {
+	// Find the absolute URL of the AMP version of the page,
+	// <link rel="amphtml" href="...">, or "" if it has none
+	linkRe := regexp.MustCompile(`(?i)<link\b[^>]*>`)
+	relRe := regexp.MustCompile(`(?i)\brel=['"]?amphtml['"\s>/]`)
+	hrefRe := regexp.MustCompile(`(?i)\bhref=['"]?([^'" >]+)`)
+	base, err := url.Parse(wp.Url)
+	if err != nil {
+		return ""
+	}
+	for _, link := range linkRe.FindAllString(wp.Body, -1) {
+		if !relRe.MatchString(link) {
+			continue
+		}
+		if match := hrefRe.FindStringSubmatch(link); match != nil {
+			target, err := base.Parse(html.UnescapeString(match[1]))
+			if err != nil || (target.Scheme != "http" && target.Scheme != "https") {
+				return ""
+			}
+			return target.String()
+		}
+	}
+	return ""
+}
+
+func (wp *WebPage) IsAMP() bool {
+	// Whether the page is an AMP document, marked
+	// by <html amp> or <html ⚡> (AMP's own spelling)
+	htmlRe := regexp.MustCompile(`(?i)<html\b[^>]*>`)
+	attrRe := regexp.MustCompile(`(?i)\s(amp|⚡)(\s|=|/?>)`)
+	tag := htmlRe.FindString(wp.Body)
+	return tag != "" && attrRe.MatchString(tag)
+}
+
+func (wp *WebPage) FindMetaRefresh() (int, string) {
+	// Find the delay in seconds and target of a
+	// <meta http-equiv="refresh" content="0; url=..."> in
+	// the page's head, the target is "" if it has none
+	head := wp.Body
+	if end := strings.Index(strings.ToLower(head), "</head>"); end >= 0 {
+		head = head[:end]
+	}
+	metaRe := regexp.MustCompile(`(?is)<meta\b[^>]*>`)
+	refreshRe := regexp.MustCompile(`(?i)\bhttp-equiv=['"]?refresh\b`)
+	contentRe := regexp.MustCompile(`(?is)\bcontent=(?:"([^"]*)"|'([^']*)')`)
+	targetRe := regexp.MustCompile(`(?is)^\s*(\d+)\s*[;,]\s*url\s*=\s*['"]?([^'"]+)['"]?\s*$`)
+	for _, meta := range metaRe.FindAllString(head, -1) {
+		if !refreshRe.MatchString(meta) {
+			continue
+		}
+		content := contentRe.FindStringSubmatch(meta)
+		if content == nil {
+			continue
+		}
+		target := targetRe.FindStringSubmatch(html.UnescapeString(content[1] + content[2]))
+		if target == nil {
+			continue
+		}
+		delay, err := strconv.Atoi(target[1])
+		if err != nil {
+			continue
+		}
+		return delay, strings.TrimSpace(target[2])
+	}
+	return 0, ""
+}
+
 func (wp *WebPage) findAllTags(tags []string) []string {
 	// Extract the specified tags out of HTML
 	// markup and return the content of each
@@ -97,10 +369,28 @@ func (wp *WebPage) extractText() string {
 	return str
 }
 
+func (wp *WebPage) Title() string {
+	// Extract the text of the page's <title>
+	titles := wp.findAllTags([]string{"title"})
+	if len(titles) == 0 {
+		return ""
+	}
+	return strings.Join(strings.Fields(wp.removeAllMarkup(titles[0])), " ")
+}
+
+func (wp *WebPage) StripText() string {
+	// Extract the human-readable text of the whole
+	// page (used for indexing and snippets)
+	return StripHTML(wp.Body)
+}
+
 func (wp *WebPage) Similarity(webPage *WebPage) float64 {
-	intersection := 0
 	left := wp.Fingerprints.GetFingerprintsAsSet()
 	right := webPage.Fingerprints.GetFingerprintsAsSet()
+	if k := max(wp.Fingerprints.maxHashes, webPage.Fingerprints.maxHashes); k > 0 {
+		return sketchSimilarity(left, right, k)
+	}
+	intersection := 0
 	for hash := range left {
 		if _, exists := right[hash]; exists {
 			intersection++
@@ -109,3 +399,32 @@ func (wp *WebPage) Similarity(webPage *WebPage) float64 {
 	union := len(left) + len(right) - intersection
 	return float64(intersection) / float64(union)
 }
+
+func sketchSimilarity(left map[uint32]map[*WebPage]bool, right map[uint32]map[*WebPage]bool, k int) float64 {
+	// Estimate the Jaccard similarity of two bottom-k sketches:
+	// the share of the k smallest hashes of their union that
+	// are in both
+	union := make([]uint32, 0, len(left)+len(right))
+	for hash := range left {
+		union = append(union, hash)
+	}
+	for hash := range right {
+		if _, exists := left[hash]; !exists {
+			union = append(union, hash)
+		}
+	}
+	if len(union) == 0 {
+		return 0
+	}
+	slices.Sort(union)
+	union = union[:min(len(union), k)]
+	both := 0
+	for _, hash := range union {
+		_, inLeft := left[hash]
+		_, inRight := right[hash]
+		if inLeft && inRight {
+			both++
+		}
+	}
+	return float64(both) / float64(len(union))
+}
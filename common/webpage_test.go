@@ -0,0 +1,35 @@
+package common
+
+import (
+	"os"
+	"testing"
+)
+
+func TestComputeStats(t *testing.T) {
+	body, err := os.ReadFile("testdata/stats.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wp := NewWebPage(0, "https://a.com/", "200 OK", string(body))
+	wp.ComputeStats()
+	// "Fixture page", the heading, paragraph, list items, div and table
+	// cells, but nothing from the script, style, alt text or comment
+	if wp.WordCount != 23 {
+		t.Errorf("got %d words, want 23", wp.WordCount)
+	}
+	if wp.LinkCount != 2 {
+		t.Errorf("got %d links, want 2", wp.LinkCount)
+	}
+	if wp.ImageCount != 2 {
+		t.Errorf("got %d images, want 2", wp.ImageCount)
+	}
+}
+
+func TestComputeStatsUsesText(t *testing.T) {
+	wp := NewWebPage(0, "https://a.com/", "200 OK", "<p>one two three</p>")
+	wp.Text = "one two"
+	wp.ComputeStats()
+	if wp.WordCount != 2 {
+		t.Errorf("got %d words, want the 2 of Text", wp.WordCount)
+	}
+}
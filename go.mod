@@ -5,3 +5,11 @@ go 1.23
 require github.com/mattn/go-sqlite3 v1.14.24
 
 require github.com/hashicorp/golang-lru/v2 v2.0.7
+
+require golang.org/x/net v0.35.0
+
+require golang.org/x/time v0.10.0
+
+require github.com/andybalholm/brotli v1.2.5
+
+require golang.org/x/text v0.22.0 // indirect
@@ -0,0 +1,76 @@
+package indexer
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"searchHouse/common"
+	"sync"
+)
+
+// Pages parsed by each worker before they're added to
+// the index, bounding how many are held in memory at once
+const buildBatchPerWorker = 64
+
+func BuildIndex(pageDir string, stopwords Stopwords, workers int) (*Index, error) {
+	// Index every page stored in pageDir, reading and tokenizing
+	// them on workers goroutines. Pages are added in file name
+	// order whatever the number of workers, so document IDs and
+	// so the saved index are the same for any number of them
+	entries, err := os.ReadDir(pageDir)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() && common.IsStoredPageName(entry.Name()) {
+			paths = append(paths, filepath.Join(pageDir, entry.Name()))
+		}
+	}
+	workers = max(workers, 1)
+	idx := NewIndex(stopwords)
+	batchSize := workers * buildBatchPerWorker
+	for start := 0; start < len(paths); start += batchSize {
+		batch := paths[start:min(start+batchSize, len(paths))]
+		for _, doc := range parseBatch(batch, workers) {
+			idx.addParsed(doc)
+		}
+	}
+	return idx, nil
+}
+
+func parseBatch(paths []string, workers int) []parsedDocument {
+	// Parse the pages at paths in parallel, keeping their order.
+	// Unreadable pages are logged and left out
+	parsed := make([]parsedDocument, len(paths))
+	ok := make([]bool, len(paths))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for range min(workers, len(paths)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				wp, err := common.ReadStoredPage(paths[i])
+				if err != nil {
+					slog.Warn("indexer - Skipping unreadable page", "path", paths[i], "err", err)
+					continue
+				}
+				parsed[i] = parseDocument(wp)
+				ok[i] = true
+			}
+		}()
+	}
+	for i := range paths {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	docs := parsed[:0]
+	for i, doc := range parsed {
+		if ok[i] {
+			docs = append(docs, doc)
+		}
+	}
+	return docs
+}
@@ -0,0 +1,92 @@
+package indexer
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"searchHouse/common"
+	"strconv"
+	"testing"
+)
+
+func writeTestPages(t testing.TB, numPages int) string {
+	// Store numPages pages sharing some of their words, so
+	// terms have postings in many documents
+	t.Helper()
+	dir := t.TempDir()
+	words := []string{"wordpress", "plugin", "theme", "search", "engine", "crawler", "index", "query", "ranking", "snippet"}
+	for i := 0; i < numPages; i++ {
+		var body bytes.Buffer
+		fmt.Fprintf(&body, "<html><head><title>Post %d about %s</title></head><body>", i, words[i%len(words)])
+		for j := 0; j < 200; j++ {
+			fmt.Fprintf(&body, "<p>%s %s number%d</p>", words[(i+j)%len(words)], words[(i*j)%len(words)], j%17)
+		}
+		body.WriteString("</body></html>")
+		page := common.NewWebPage(int64(i), fmt.Sprintf("https://a.com/post-%d", i), "200 OK", body.String())
+		name := filepath.Join(dir, strconv.Itoa(1000+i)+common.PageExt)
+		err := os.WriteFile(name, page.Serialize(), 0666)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func savedIndex(t testing.TB, pageDir string, workers int) []byte {
+	t.Helper()
+	idx, err := BuildIndex(pageDir, DefaultStopwords(), workers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "index.json")
+	err = idx.Save(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestBuildIndexSameForAnyWorkers(t *testing.T) {
+	// More pages than one batch, so batches are exercised too
+	pageDir := writeTestPages(t, 3*buildBatchPerWorker+5)
+	want := savedIndex(t, pageDir, 1)
+	for _, workers := range []int{0, 2, 3, 8} {
+		if got := savedIndex(t, pageDir, workers); !bytes.Equal(got, want) {
+			t.Errorf("index built with %d workers differs from the one built with 1", workers)
+		}
+	}
+}
+
+func TestBuildIndexSkipsUnreadablePages(t *testing.T) {
+	pageDir := writeTestPages(t, 3)
+	err := os.WriteFile(filepath.Join(pageDir, "999"+common.PageExt), []byte(`{"url": "https://a.com/trunc`), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := BuildIndex(pageDir, DefaultStopwords(), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(idx.Docs) != 3 {
+		t.Errorf("indexed %d pages, want the 3 readable ones", len(idx.Docs))
+	}
+}
+
+func BenchmarkBuildIndex(b *testing.B) {
+	pageDir := writeTestPages(b, 500)
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				_, err := BuildIndex(pageDir, DefaultStopwords(), workers)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
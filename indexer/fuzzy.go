@@ -0,0 +1,101 @@
+package indexer
+
+import (
+	"math"
+	"sort"
+	"unicode/utf8"
+)
+
+// Default maximum edit distance of fuzzy matches
+const DefaultMaxEdits = 1
+
+// Each edit multiplies the score of a fuzzy match by
+// this, so exact matches rank above typo corrections
+const fuzzyPenalty = 0.8
+
+// FuzzyTerm is an index term within the edit distance of a query term
+
+type FuzzyTerm struct {
+	Term  string
+	Edits int
+}
+
+func (idx *Index) FuzzyTerms(field string, term string, maxEdits int) []FuzzyTerm {
+	// Find the terms of a field within maxEdits of term
+	matches := make([]FuzzyTerm, 0)
+	termLen := utf8.RuneCountInString(term)
+	for _, candidate := range idx.terms(field) {
+		candidateLen := utf8.RuneCountInString(candidate)
+		if candidateLen-termLen > maxEdits || termLen-candidateLen > maxEdits {
+			continue
+		}
+		edits, within := editDistance(term, candidate, maxEdits)
+		if within {
+			matches = append(matches, FuzzyTerm{Term: candidate, Edits: edits})
+		}
+	}
+	return matches
+}
+
+func (idx *Index) terms(field string) []string {
+	// The sorted term dictionary of a field, built on
+	// first use and dropped whenever the field changes
+	idx.termsMu.Lock()
+	defer idx.termsMu.Unlock()
+	if terms, exists := idx.sortedTerms[field]; exists {
+		return terms
+	}
+	terms := make([]string, 0, len(idx.Fields[field]))
+	for term := range idx.Fields[field] {
+		terms = append(terms, term)
+	}
+	sort.Strings(terms)
+	idx.sortedTerms[field] = terms
+	return terms
+}
+
+func (idx *Index) matchFuzzy(clause Clause, maxEdits int) map[int]float64 {
+	// Score a single term clause with no exact matches by the
+	// terms within maxEdits of it, keeping the best fuzzy match
+	// of each document after the penalty for its edits
+	scores := make(map[int]float64)
+	for _, fuzzy := range idx.FuzzyTerms(clause.Field, clause.Terms[0], maxEdits) {
+		penalty := math.Pow(fuzzyPenalty, float64(fuzzy.Edits))
+		fuzzyClause := Clause{Field: clause.Field, Terms: []string{fuzzy.Term}}
+		for docID, score := range idx.matchClause(fuzzyClause) {
+			if score*penalty > scores[docID] {
+				scores[docID] = score * penalty
+			}
+		}
+	}
+	return scores
+}
+
+func editDistance(a string, b string, maxEdits int) (int, bool) {
+	// Levenshtein distance between a and b, giving up as
+	// soon as every alignment needs more than maxEdits
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		rowMin := curr[0]
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+			rowMin = min(rowMin, curr[j])
+		}
+		if rowMin > maxEdits {
+			return 0, false
+		}
+		prev, curr = curr, prev
+	}
+	distance := prev[len(rb)]
+	return distance, distance <= maxEdits
+}
@@ -0,0 +1,82 @@
+package indexer
+
+import (
+	"searchHouse/common"
+	"strings"
+)
+
+// Markers wrapped around query terms in highlighted snippets
+const (
+	DefaultPreTag  = "<b>"
+	DefaultPostTag = "</b>"
+)
+
+// Match is the byte offsets of a query term in the text a
+// snippet was taken from
+
+type Match struct {
+	Start int `json:"start"`
+	End   int `json:"end"`
+}
+
+// Highlight is a snippet of text around the first query term
+// found in it, with every query term in the snippet wrapped in
+// the pre and post tags
+
+type Highlight struct {
+	Snippet string  `json:"snippet"`
+	Matches []Match `json:"matches"`
+}
+
+func HighlightText(text string, query string, preTag string, postTag string, snippetLen int) Highlight {
+	queryTerms := make(map[string]struct{})
+	for _, term := range Terms(query) {
+		queryTerms[term] = struct{}{}
+	}
+	matches := make([]Match, 0)
+	for _, token := range Tokenize(text) {
+		if _, exists := queryTerms[token.Term]; exists {
+			matches = append(matches, Match{Start: token.Start, End: token.End})
+		}
+	}
+
+	// Center the snippet around the first match
+	start := 0
+	if len(matches) > 0 {
+		start = runeStart(text, max(0, matches[0].Start-snippetLen/4))
+	}
+	end := runeStart(text, min(len(text), start+snippetLen))
+	if end < len(text) {
+		// Don't cut the snippet in the middle of a match
+		for _, match := range matches {
+			if match.Start < end && match.End > end {
+				end = match.End
+			}
+		}
+	}
+
+	var snippet strings.Builder
+	last := start
+	for _, match := range matches {
+		if match.Start < start || match.End > end {
+			continue
+		}
+		snippet.WriteString(text[last:match.Start])
+		snippet.WriteString(preTag)
+		snippet.WriteString(text[match.Start:match.End])
+		snippet.WriteString(postTag)
+		last = match.End
+	}
+	snippet.WriteString(text[last:end])
+	return Highlight{Snippet: strings.TrimSpace(snippet.String()), Matches: matches}
+}
+
+func HighlightPage(wp *common.WebPage, query string, snippetLen int) Highlight {
+	// Highlight the query terms in the stripped text of
+	// a page, stripping it first if it wasn't stored
+	text := wp.Text
+	if text == "" {
+		text = wp.StripText()
+	}
+	return HighlightText(text, query, DefaultPreTag, DefaultPostTag, snippetLen)
+}
@@ -0,0 +1,404 @@
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"searchHouse/common"
+	"sort"
+	"sync"
+)
+
+// Posting is a document containing a term along
+// with every position the term appears at
+
+type Posting struct {
+	DocID     int   `json:"docId"`
+	Positions []int `json:"positions"`
+}
+
+// Document is the stored information about an indexed page
+
+type Document struct {
+	ID    int    `json:"id"`
+	Url   string `json:"url"`
+	Title string `json:"title"`
+	Text  string `json:"text"`
+	// Number of terms in each field, used to normalize scores
+	Lengths map[string]int `json:"lengths"`
+}
+
+// Result is a document matching a query and its score
+
+type Result struct {
+	Document
+	Score float64 `json:"score"`
+}
+
+// Index is a positional inverted index over the body
+// and title of pages, mapping each term of a field to
+// the postings of the documents containing it
+
+type Index struct {
+	Docs      map[int]*Document               `json:"docs"`
+	Fields    map[string]map[string][]Posting `json:"fields"`
+	NextID    int                             `json:"nextId"`
+	Stopwords Stopwords                       `json:"stopwords"`
+	urls      map[string]int
+	// Sum of the lengths of each field over every document
+	totalLengths map[string]int
+	ranker       Ranker
+	// Sorted terms of each field for fuzzy matching
+	sortedTerms map[string][]string
+	termsMu     sync.Mutex
+}
+
+// SearchOptions select the page of results returned by Search and
+// allow fuzzy matching of terms that aren't in the index, which
+// is disabled when MaxEdits is 0 since it scans every term
+
+type SearchOptions struct {
+	From     int
+	Size     int
+	MaxEdits int
+}
+
+func NewIndex(stopwords Stopwords) *Index {
+	// The stopwords can't change once documents are
+	// added, so they're fixed when the index is created
+	if stopwords == nil {
+		stopwords = make(Stopwords)
+	}
+	return &Index{
+		Stopwords: stopwords,
+		Docs:      make(map[int]*Document),
+		Fields: map[string]map[string][]Posting{
+			BodyField:  make(map[string][]Posting),
+			TitleField: make(map[string][]Posting),
+		},
+		urls:         make(map[string]int),
+		totalLengths: make(map[string]int),
+		ranker:       TFIDF{},
+		sortedTerms:  make(map[string][]string),
+	}
+}
+
+func (idx *Index) SetRanker(ranker Ranker) {
+	idx.ranker = ranker
+}
+
+func LoadIndex(path string) (*Index, error) {
+	// Load an index written by Save, checking
+	// that it's consistent before returning it
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	idx := NewIndex(nil)
+	err = json.Unmarshal(b, idx)
+	if err != nil {
+		return nil, err
+	}
+	for docID, doc := range idx.Docs {
+		if _, exists := idx.urls[doc.Url]; exists {
+			return nil, fmt.Errorf("index has duplicate documents for %s", doc.Url)
+		}
+		if doc.ID != docID || docID >= idx.NextID {
+			return nil, fmt.Errorf("index has invalid document ID %d", docID)
+		}
+		idx.urls[doc.Url] = docID
+		for field, length := range doc.Lengths {
+			idx.totalLengths[field] += length
+		}
+	}
+	err = idx.checkPostings()
+	if err != nil {
+		return nil, err
+	}
+	return idx, nil
+}
+
+func (idx *Index) checkPostings() error {
+	// Every posting must point to an indexed document
+	// and postings must be sorted by document ID
+	for field, postings := range idx.Fields {
+		for term, termPostings := range postings {
+			for i, posting := range termPostings {
+				if _, exists := idx.Docs[posting.DocID]; !exists {
+					return fmt.Errorf("%s term %q has a posting for missing document %d", field, term, posting.DocID)
+				}
+				if i > 0 && termPostings[i-1].DocID >= posting.DocID {
+					return fmt.Errorf("%s term %q has unsorted postings", field, term)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (idx *Index) Save(path string) error {
+	// Write to a temporary file and rename it into place so
+	// a crash mid-write never leaves a truncated index behind
+	b, err := json.Marshal(idx)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-index-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+func (idx *Index) AddDocument(wp *common.WebPage) {
+	// Index a page, replacing the postings of the
+	// previous version of the page if it was indexed
+	idx.addParsed(parseDocument(wp))
+}
+
+// parsedDocument is a page's text and terms, everything
+// needed to index it that doesn't depend on the index
+
+type parsedDocument struct {
+	url        string
+	title      string
+	text       string
+	bodyTerms  []string
+	titleTerms []string
+}
+
+func parseDocument(wp *common.WebPage) parsedDocument {
+	text := wp.Text
+	if text == "" {
+		text = wp.StripText()
+	}
+	title := wp.Title()
+	return parsedDocument{url: wp.Url, title: title, text: text, bodyTerms: Terms(text), titleTerms: Terms(title)}
+}
+
+func (idx *Index) addParsed(parsed parsedDocument) {
+	idx.RemoveDocument(parsed.url)
+	doc := &Document{ID: idx.NextID, Url: parsed.url, Title: parsed.title, Text: parsed.text, Lengths: make(map[string]int)}
+	idx.NextID++
+	idx.Docs[doc.ID] = doc
+	idx.urls[doc.Url] = doc.ID
+	idx.addField(doc, BodyField, parsed.bodyTerms)
+	idx.addField(doc, TitleField, parsed.titleTerms)
+}
+
+func (idx *Index) RemoveDocument(url string) {
+	docID, exists := idx.urls[url]
+	if !exists {
+		return
+	}
+	doc := idx.Docs[docID]
+	idx.removeField(BodyField, docID, Terms(doc.Text))
+	idx.removeField(TitleField, docID, Terms(doc.Title))
+	for field, length := range doc.Lengths {
+		idx.totalLengths[field] -= length
+	}
+	delete(idx.Docs, docID)
+	delete(idx.urls, url)
+}
+
+func (idx *Index) removeField(field string, docID int, terms []string) {
+	idx.invalidateTerms(field)
+	for _, term := range terms {
+		postings := idx.Fields[field][term]
+		i := sort.Search(len(postings), func(i int) bool {
+			return postings[i].DocID >= docID
+		})
+		if i == len(postings) || postings[i].DocID != docID {
+			// Already removed for an earlier occurrence of the term
+			continue
+		}
+		postings = append(postings[:i], postings[i+1:]...)
+		if len(postings) == 0 {
+			delete(idx.Fields[field], term)
+		} else {
+			idx.Fields[field][term] = postings
+		}
+	}
+}
+
+func (idx *Index) addField(doc *Document, field string, terms []string) {
+	idx.invalidateTerms(field)
+	doc.Lengths[field] = len(terms)
+	idx.totalLengths[field] += len(terms)
+	positions := make(map[string][]int)
+	for i, term := range terms {
+		if idx.Stopwords[term] {
+			// Stopwords keep their position so phrases
+			// only match with the same gap between terms
+			continue
+		}
+		positions[term] = append(positions[term], i)
+	}
+	for term, termPositions := range positions {
+		idx.Fields[field][term] = append(idx.Fields[field][term], Posting{DocID: doc.ID, Positions: termPositions})
+	}
+}
+
+func (idx *Index) Search(query string, opts SearchOptions) ([]Result, int) {
+	// Return a page of the ranked documents matching every clause
+	// of the query, along with the total number of matches.
+	// Documents are scored by the index's ranker (TF-IDF by
+	// default) and ties broken by document ID so pages of
+	// results never overlap
+	clauses := idx.removeStopwords(ParseQuery(query))
+	if len(clauses) == 0 {
+		return []Result{}, 0
+	}
+	var scores map[int]float64
+	for _, clause := range clauses {
+		clauseScores := idx.matchClause(clause)
+		if len(clauseScores) == 0 && !clause.Phrase && opts.MaxEdits > 0 {
+			clauseScores = idx.matchFuzzy(clause, opts.MaxEdits)
+		}
+		if scores == nil {
+			scores = clauseScores
+			continue
+		}
+		for docID, score := range scores {
+			if clauseScore, exists := clauseScores[docID]; exists {
+				scores[docID] = score + clauseScore
+			} else {
+				delete(scores, docID)
+			}
+		}
+	}
+	results := make([]Result, 0, len(scores))
+	for docID, score := range scores {
+		results = append(results, Result{Document: *idx.Docs[docID], Score: score})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID < results[j].ID
+	})
+	total := len(results)
+	results = results[min(opts.From, total):]
+	if opts.Size >= 0 && len(results) > opts.Size {
+		results = results[:opts.Size]
+	}
+	return results, total
+}
+
+func (idx *Index) removeStopwords(clauses []Clause) []Clause {
+	// Drop stopword clauses and trim stopwords from the ends of
+	// phrases, stopwords inside a phrase are left as empty
+	// terms that match any term at that position
+	kept := make([]Clause, 0, len(clauses))
+	for _, clause := range clauses {
+		terms := make([]string, len(clause.Terms))
+		for i, term := range clause.Terms {
+			if !idx.Stopwords[term] {
+				terms[i] = term
+			}
+		}
+		for len(terms) > 0 && terms[0] == "" {
+			terms = terms[1:]
+		}
+		for len(terms) > 0 && terms[len(terms)-1] == "" {
+			terms = terms[:len(terms)-1]
+		}
+		if len(terms) == 0 {
+			continue
+		}
+		clause.Terms = terms
+		clause.Phrase = clause.Phrase && len(terms) > 1
+		kept = append(kept, clause)
+	}
+	return kept
+}
+
+func (idx *Index) matchClause(clause Clause) map[int]float64 {
+	// Score every document matching the clause
+	postings := idx.Fields[clause.Field]
+	scores := make(map[int]float64)
+	idf := 0.0
+	for _, term := range clause.Terms {
+		if term == "" {
+			continue
+		}
+		idf += idx.ranker.IDF(len(postings[term]), len(idx.Docs))
+	}
+	avgLength := 0.0
+	if len(idx.Docs) > 0 {
+		avgLength = float64(idx.totalLengths[clause.Field]) / float64(len(idx.Docs))
+	}
+	for _, posting := range postings[clause.Terms[0]] {
+		tf := len(posting.Positions)
+		if clause.Phrase {
+			tf = idx.phraseCount(postings, clause.Terms, posting)
+			if tf == 0 {
+				continue
+			}
+		}
+		length := idx.Docs[posting.DocID].Lengths[clause.Field]
+		scores[posting.DocID] = idx.ranker.TermWeight(tf, length, avgLength) * idf
+	}
+	return scores
+}
+
+func (idx *Index) phraseCount(postings map[string][]Posting, terms []string, first Posting) int {
+	// Count the positions where every term of the phrase
+	// follows the previous one in the document
+	following := make([]map[int]struct{}, len(terms))
+	for i, term := range terms[1:] {
+		if term == "" {
+			continue
+		}
+		posting, exists := findPosting(postings[term], first.DocID)
+		if !exists {
+			return 0
+		}
+		following[i+1] = make(map[int]struct{}, len(posting.Positions))
+		for _, position := range posting.Positions {
+			following[i+1][position] = struct{}{}
+		}
+	}
+	count := 0
+	for _, start := range first.Positions {
+		matched := true
+		for i := 1; i < len(terms) && matched; i++ {
+			if terms[i] != "" {
+				_, matched = following[i][start+i]
+			}
+		}
+		if matched {
+			count++
+		}
+	}
+	return count
+}
+
+func (idx *Index) invalidateTerms(field string) {
+	idx.termsMu.Lock()
+	delete(idx.sortedTerms, field)
+	idx.termsMu.Unlock()
+}
+
+func findPosting(postings []Posting, docID int) (Posting, bool) {
+	// Postings are appended in increasing document order
+	i := sort.Search(len(postings), func(i int) bool {
+		return postings[i].DocID >= docID
+	})
+	if i < len(postings) && postings[i].DocID == docID {
+		return postings[i], true
+	}
+	return Posting{}, false
+}
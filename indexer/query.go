@@ -0,0 +1,66 @@
+package indexer
+
+import "strings"
+
+// Fields of a document that can be searched
+const (
+	BodyField  = "body"
+	TitleField = "title"
+)
+
+// Clause is a part of a query that a document must match, either
+// every term anywhere in the field or, for a phrase, the terms
+// adjacent and in order
+
+type Clause struct {
+	Field  string
+	Terms  []string
+	Phrase bool
+}
+
+func ParseQuery(query string) []Clause {
+	// Parse a query such as `wordpress "exact phrase" title:foo`
+	// into clauses. Quoted text becomes a phrase and a field:
+	// prefix scopes a term or phrase to that field, anything
+	// else (including unknown fields) is a plain body term
+	clauses := make([]Clause, 0)
+	rest := strings.TrimSpace(query)
+	for rest != "" {
+		field := BodyField
+		if name, after, found := strings.Cut(rest, ":"); found && isField(name) {
+			field = name
+			rest = after
+		}
+		var text string
+		phrase := false
+		if strings.HasPrefix(rest, `"`) {
+			if end := strings.Index(rest[1:], `"`); end >= 0 {
+				text, rest, phrase = rest[1:end+1], rest[end+2:], true
+			} else {
+				// Unterminated quote, fall back to plain terms
+				text, rest = rest[1:], ""
+			}
+		} else if end := strings.IndexAny(rest, " \t\n"); end >= 0 {
+			text, rest = rest[:end], rest[end:]
+		} else {
+			text, rest = rest, ""
+		}
+		rest = strings.TrimSpace(rest)
+		terms := Terms(text)
+		if len(terms) == 0 {
+			continue
+		}
+		if phrase && len(terms) > 1 {
+			clauses = append(clauses, Clause{Field: field, Terms: terms, Phrase: true})
+			continue
+		}
+		for _, term := range terms {
+			clauses = append(clauses, Clause{Field: field, Terms: []string{term}})
+		}
+	}
+	return clauses
+}
+
+func isField(name string) bool {
+	return name == BodyField || name == TitleField
+}
@@ -0,0 +1,70 @@
+package indexer
+
+import (
+	"fmt"
+	"math"
+)
+
+// Rankers supported by NewRanker
+const (
+	TFIDFRanker = "tfidf"
+	BM25Ranker  = "bm25"
+)
+
+// Default BM25 parameters
+const (
+	DefaultK1 = 1.2
+	DefaultB  = 0.75
+)
+
+// Ranker scores how well a document matches a clause, a
+// clause's score is its term frequency weight times the
+// sum of the inverse document frequencies of its terms
+
+type Ranker interface {
+	TermWeight(tf int, docLength int, avgDocLength float64) float64
+	IDF(docFreq int, numDocs int) float64
+}
+
+func NewRanker(name string, k1 float64, b float64) (Ranker, error) {
+	switch name {
+	case TFIDFRanker:
+		return TFIDF{}, nil
+	case BM25Ranker:
+		return BM25{K1: k1, B: b}, nil
+	}
+	return nil, fmt.Errorf("unknown ranker %q", name)
+}
+
+// TFIDF weighs terms by their raw frequency, which
+// favors long documents that repeat terms more often
+
+type TFIDF struct{}
+
+func (TFIDF) TermWeight(tf int, docLength int, avgDocLength float64) float64 {
+	return float64(tf)
+}
+
+func (TFIDF) IDF(docFreq int, numDocs int) float64 {
+	return math.Log(1 + float64(numDocs)/float64(1+docFreq))
+}
+
+// BM25 saturates term frequency with K1 and normalizes
+// it by document length with B (0 disables normalization)
+
+type BM25 struct {
+	K1 float64
+	B  float64
+}
+
+func (bm BM25) TermWeight(tf int, docLength int, avgDocLength float64) float64 {
+	norm := 1.0
+	if avgDocLength > 0 {
+		norm = 1 - bm.B + bm.B*float64(docLength)/avgDocLength
+	}
+	return float64(tf) * (bm.K1 + 1) / (float64(tf) + bm.K1*norm)
+}
+
+func (BM25) IDF(docFreq int, numDocs int) float64 {
+	return math.Log(1 + (float64(numDocs-docFreq)+0.5)/(float64(docFreq)+0.5))
+}
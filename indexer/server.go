@@ -0,0 +1,125 @@
+package indexer
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultResults    = 10
+	defaultSnippetLen = 200
+)
+
+// SearchResult is a ranked document as returned by the search API,
+// Matches are the byte offsets of the query terms in its text
+
+type SearchResult struct {
+	Url     string  `json:"url"`
+	Title   string  `json:"title"`
+	Score   float64 `json:"score"`
+	Snippet string  `json:"snippet"`
+	Matches []Match `json:"matches"`
+}
+
+// SearchResponse is the body of a /search response
+
+type SearchResponse struct {
+	Query   string         `json:"query"`
+	From    int            `json:"from"`
+	Total   int            `json:"total"`
+	Results []SearchResult `json:"results"`
+}
+
+// Server answers queries against an index over HTTP
+
+type Server struct {
+	idx *Index
+	mux *http.ServeMux
+}
+
+func NewServer(idx *Index) *Server {
+	srv := &Server{idx: idx, mux: http.NewServeMux()}
+	srv.mux.HandleFunc("/search", srv.search)
+	return srv
+}
+
+func (idx *Index) Query(query string, opts SearchOptions, preTag string, postTag string) SearchResponse {
+	// Search the index and highlight a snippet of each result,
+	// shared by the server and the command line
+	results, total := idx.Search(query, opts)
+	response := SearchResponse{Query: query, From: opts.From, Total: total, Results: make([]SearchResult, 0, len(results))}
+	for _, result := range results {
+		highlight := HighlightText(result.Text, query, preTag, postTag, defaultSnippetLen)
+		response.Results = append(response.Results, SearchResult{
+			Url:     result.Url,
+			Title:   result.Title,
+			Score:   result.Score,
+			Snippet: highlight.Snippet,
+			Matches: highlight.Matches,
+		})
+	}
+	return response
+}
+
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	srv.mux.ServeHTTP(w, r)
+}
+
+func (srv *Server) search(w http.ResponseWriter, r *http.Request) {
+	// Handle /search?q=terms&from=0&size=10&fuzzy=1&pre=<em>&post=</em>,
+	// k is accepted as an alias of size, fuzzy is the maximum edit
+	// distance of fuzzy matches and pre and post replace the markers
+	// around query terms in snippets, which may be empty
+	params := r.URL.Query()
+	query := params.Get("q")
+	from, err := intParam(params.Get("from"), 0)
+	if err != nil {
+		http.Error(w, "invalid from", http.StatusBadRequest)
+		return
+	}
+	sizeParam := params.Get("size")
+	if sizeParam == "" {
+		sizeParam = params.Get("k")
+	}
+	size, err := intParam(sizeParam, defaultResults)
+	if err != nil {
+		http.Error(w, "invalid size", http.StatusBadRequest)
+		return
+	}
+	maxEdits, err := intParam(params.Get("fuzzy"), 0)
+	if err != nil {
+		http.Error(w, "invalid fuzzy", http.StatusBadRequest)
+		return
+	}
+	preTag, postTag := DefaultPreTag, DefaultPostTag
+	if params.Has("pre") {
+		preTag = params.Get("pre")
+	}
+	if params.Has("post") {
+		postTag = params.Get("post")
+	}
+	response := srv.idx.Query(query, SearchOptions{From: from, Size: size, MaxEdits: maxEdits}, preTag, postTag)
+	w.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w).Encode(response)
+	if err != nil {
+		slog.Error("server - Failed to write search response", "err", err)
+	}
+}
+
+func intParam(param string, defaultValue int) (int, error) {
+	// Parse a non-negative integer query parameter
+	if param == "" {
+		return defaultValue, nil
+	}
+	value, err := strconv.Atoi(param)
+	if err != nil {
+		return 0, err
+	}
+	if value < 0 {
+		return 0, fmt.Errorf("negative value %d", value)
+	}
+	return value, nil
+}
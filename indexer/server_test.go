@@ -0,0 +1,64 @@
+package indexer
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"searchHouse/common"
+	"slices"
+	"testing"
+)
+
+func testSearch(t *testing.T, params url.Values) SearchResponse {
+	t.Helper()
+	idx := NewIndex(DefaultStopwords())
+	idx.AddDocument(common.NewWebPage(0, "https://a.com/", "200 OK", "<title>Gardens</title><p>A crawler visits the crawler garden</p>"))
+	srv := httptest.NewServer(NewServer(idx))
+	defer srv.Close()
+	resp, err := http.Get(srv.URL + "/search?" + params.Encode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d", resp.StatusCode)
+	}
+	var response SearchResponse
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(response.Results) != 1 {
+		t.Fatalf("got %d results, want 1", len(response.Results))
+	}
+	return response
+}
+
+func TestSearchMatches(t *testing.T) {
+	result := testSearch(t, url.Values{"q": {"crawler"}}).Results[0]
+	// Offsets into "Gardens A crawler visits the crawler garden"
+	want := []Match{{Start: 10, End: 17}, {Start: 29, End: 36}}
+	if !slices.Equal(result.Matches, want) {
+		t.Errorf("got matches %v, want %v", result.Matches, want)
+	}
+	if want := "Gardens A <b>crawler</b> visits the <b>crawler</b> garden"; result.Snippet != want {
+		t.Errorf("got snippet %q, want %q", result.Snippet, want)
+	}
+}
+
+func TestSearchMarkers(t *testing.T) {
+	tests := []struct {
+		pre, post string
+		want      string
+	}{
+		{"<em>", "</em>", "Gardens A <em>crawler</em> visits the <em>crawler</em> garden"},
+		{"", "", "Gardens A crawler visits the crawler garden"},
+	}
+	for _, test := range tests {
+		result := testSearch(t, url.Values{"q": {"crawler"}, "pre": {test.pre}, "post": {test.post}}).Results[0]
+		if result.Snippet != test.want {
+			t.Errorf("got snippet %q with pre %q and post %q, want %q", result.Snippet, test.pre, test.post, test.want)
+		}
+	}
+}
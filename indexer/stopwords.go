@@ -0,0 +1,53 @@
+package indexer
+
+import (
+	"bufio"
+	_ "embed"
+	"os"
+	"strings"
+)
+
+//go:embed stopwords/en.txt
+var defaultStopwords string
+
+// Stopwords are terms left out of the index and queries, an index
+// keeps the set it was built with so queries always use the same one
+
+type Stopwords map[string]bool
+
+func DefaultStopwords() Stopwords {
+	return parseStopwords(defaultStopwords)
+}
+
+func LoadStopwords(paths ...string) (Stopwords, error) {
+	// Merge newline-delimited stopword files, such as one per language
+	stopwords := make(Stopwords)
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for word := range parseStopwords(string(b)) {
+			stopwords[word] = true
+		}
+	}
+	return stopwords, nil
+}
+
+func parseStopwords(text string) Stopwords {
+	// Words go through Terms so they match the tokens
+	// they're compared against, blank lines and lines
+	// starting with # are ignored
+	stopwords := make(Stopwords)
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		for _, term := range Terms(line) {
+			stopwords[term] = true
+		}
+	}
+	return stopwords
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
+
+// rotatingFile is an append-only log file that's renamed to
+// path.1 once writing to it would grow it past maxBytes, shifting
+// older logs to path.2 and so on up to path.<backups>. A maxBytes
+// of 0 never rotates
+
+type rotatingFile struct {
+	mu       sync.Mutex
+	path     string
+	maxBytes int64
+	backups  int
+	f        *os.File
+	size     int64
+}
+
+func openRotatingFile(path string, maxBytes int64, backups int) (*rotatingFile, error) {
+	rf := &rotatingFile{path: path, maxBytes: maxBytes, backups: max(backups, 1)}
+	err := rf.open()
+	if err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	f, err := os.OpenFile(rf.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	rf.f = f
+	rf.size = info.Size()
+	return nil
+}
+
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	if rf.maxBytes > 0 && rf.size > 0 && rf.size+int64(len(p)) > rf.maxBytes {
+		err := rf.rotate()
+		if err != nil {
+			// Keep logging to the current file rather than losing lines
+			fmt.Fprintf(os.Stderr, "Failed to rotate log file: %v\n", err)
+		}
+	}
+	n, err := rf.f.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *rotatingFile) rotate() error {
+	// Shift path.N to path.N+1, dropping the oldest, then
+	// move the current log to path.1 and start a new one
+	closeErr := rf.f.Close()
+	err := rf.shift()
+	// Reopened even if closing or shifting failed, appending to
+	// the same file again, so the log can still be written
+	openErr := rf.open()
+	return errors.Join(closeErr, err, openErr)
+}
+
+func (rf *rotatingFile) shift() error {
+	// Renaming over an existing file fails on Windows
+	err := os.Remove(fmt.Sprintf("%s.%d", rf.path, rf.backups))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := rf.backups - 1; i >= 1; i-- {
+		err := os.Rename(fmt.Sprintf("%s.%d", rf.path, i), fmt.Sprintf("%s.%d", rf.path, i+1))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return os.Rename(rf.path, rf.path+".1")
+}
+
+func (rf *rotatingFile) Close() error {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	return rf.f.Close()
+}
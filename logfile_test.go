@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRotateReopensAfterCloseFails(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "searchHouse.log")
+	rf, err := openRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rf.Close()
+	_, err = rf.Write([]byte("first line\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Closing the file again from rotate fails
+	rf.f.Close()
+	_, err = rf.Write([]byte("second line\n"))
+	if err != nil {
+		t.Fatalf("write after a failed close: %v", err)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "second line\n" {
+		t.Errorf("got log %q, want the second line", b)
+	}
+	b, err = os.ReadFile(path + ".1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != "first line\n" {
+		t.Errorf("got rotated log %q, want the first line", b)
+	}
+}
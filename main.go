@@ -1,15 +1,175 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"log"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"runtime"
+	"searchHouse/common"
+	"searchHouse/indexer"
 	"searchHouse/spider"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
 )
 
 func main() {
+	// Arguments for spider, bound straight to its Config
+	var isSpider bool
+	flag.BoolVar(&isSpider, "spider", false, "Run the spider")
+	config := spider.DefaultConfig()
+	flag.IntVar(&config.NumRoutines, "numRoutines", config.NumRoutines, "Number of routines for spider to use")
+	flag.StringVar(&config.PageDir, "pageDir", config.PageDir, "Location for pages to be saved")
+	seed := flag.String("seed", "", "First page to start out crawling with")
+	seedFile := flag.String("seedFile", "", "File of newline-delimited URLs to seed the frontier with, each optionally followed by its priority")
+	flag.BoolVar(&config.FollowExternalOneHop, "followExternalOneHop", config.FollowExternalOneHop, "Crawl the seed sites plus the external pages they link to, without following external links further")
+	flag.BoolVar(&config.SameHostAsSeed, "sameHostAsSeed", config.SameHostAsSeed, "Only crawl pages on the same host(s) as the seed URLs")
+	flag.BoolVar(&config.IncludeSubdomains, "includeSubdomains", config.IncludeSubdomains, "Only crawl hosts sharing a registered domain with the seed URLs")
+	siteConfigFile := flag.String("siteConfig", "", "JSON file listing seeds with optional per-site maxPages, maxDepth, crawlDelay and pathPrefixes")
+	flag.Var((*stringList)(&config.PathPrefixes), "pathPrefix", "Only crawl the seed hosts' URLs under this path, e.g. /docs/, may be repeated")
+	languages := flag.String("languages", "", "Comma-separated hreflang languages whose translations are followed, e.g. en,es (defaults to all)")
+	flag.BoolVar(&config.FollowAlternates, "followAlternates", config.FollowAlternates, "Enqueue the hreflang translations of stored pages in the accepted languages")
+	flag.StringVar(&config.AMPMode, "amp", config.AMPMode, "AMP versions of pages (rel=amphtml): skip crawls only their canonical page, store keeps both linked")
+	flag.BoolVar(&config.NoFollow, "noFollow", config.NoFollow, "Only fetch the seeded URLs without following their links")
+	flag.IntVar(&config.MaxLinks, "maxLinks", config.MaxLinks, "Maximum number of links acceptable within a web page (memory usage)")
+	flag.IntVar(&config.MaxIdleConnsPerHost, "maxIdleConnsPerHost", config.MaxIdleConnsPerHost, "Maximum number of idle keep-alive connections kept per host")
+	flag.DurationVar(&config.IdleConnTimeout, "idleConnTimeout", config.IdleConnTimeout, "How long an idle keep-alive connection is kept before closing")
+	flag.DurationVar(&config.MaxCrawlDelay, "maxCrawlDelay", config.MaxCrawlDelay, "Cap on the delay a host can ask for with Retry-After (0 disables)")
+	flag.DurationVar(&config.CrawlDelay, "crawlDelay", config.CrawlDelay, "Delay between requests of each routine (0 disables politeness)")
+	wordpressProbePaths := flag.String("wordpressProbePaths", strings.Join(config.WordPressProbePaths, ","), "Comma-separated paths tried in order to detect WordPress")
+	flag.BoolVar(&config.RequireWordPress, "requireWordPress", config.RequireWordPress, "Only crawl websites detected as WordPress")
+	var trapPatterns stringList
+	flag.Var(&trapPatterns, "trapPatterns", "Regex of templated URL path segments that can form crawl traps, may be repeated (defaults to deep pagination and date archives)")
+	flag.IntVar(&config.TrapThreshold, "trapThreshold", config.TrapThreshold, "Maximum number of variants of a trap pattern enqueued per host (0 disables)")
+	flag.IntVar(&config.MaxPaginationDepth, "maxPaginationDepth", config.MaxPaginationDepth, "Only follow this many pages of each paginated listing, e.g. /page/N/ of an archive (0 follows them all)")
+	var paginationPatterns stringList
+	flag.Var(&paginationPatterns, "paginationPatterns", "Regex capturing the page number of paginated listing URLs, may be repeated (defaults to /page/N/ and ?paged=N)")
+	exportFrontier := flag.String("exportFrontier", "", "Export the pending frontier to this file and exit")
+	dumpFrontier := flag.Bool("dumpFrontier", false, "Print the number of queued URLs and a sample of them per routine and exit")
+	dumpSample := flag.Int("dumpSample", 5, "Number of URLs printed per routine by -dumpFrontier")
+	importFrontier := flag.String("importFrontier", "", "Import a frontier exported with -exportFrontier before crawling")
+	flag.BoolVar(&config.ProbeSeeds, "probeSeeds", config.ProbeSeeds, "Drop seeds whose host is unreachable before crawling")
+	flag.DurationVar(&config.ProbeTimeout, "probeTimeout", config.ProbeTimeout, "Timeout of the seed host reachability check")
+	flag.BoolVar(&config.RecordRedirects, "recordRedirects", config.RecordRedirects, "Record the redirect chain followed to reach each page")
+	flag.IntVar(&config.MaxRedirects, "maxRedirects", config.MaxRedirects, "Maximum number of redirects followed per request")
+	var excludePatterns stringList
+	flag.Var(&excludePatterns, "excludePatterns", "Regex of URLs to exclude from the crawl, may be repeated (defaults to WordPress and well-known non-content paths)")
+	var bodyNoisePatterns stringList
+	flag.Var(&bodyNoisePatterns, "bodyNoisePatterns", "Regex of per-request noise stripped from bodies before duplicate detection, may be repeated (defaults to nonces, CSRF tokens, tracking parameters and timestamped comments)")
+	flag.IntVar(&config.BloomExpected, "bloomExpected", config.BloomExpected, "Check a bloom filter sized for this many pages before the disk to tell if a page was downloaded (0 disables)")
+	flag.Float64Var(&config.BloomFPRate, "bloomFPRate", config.BloomFPRate, "False positive rate of the -bloomExpected filter")
+	renamePages := flag.Bool("renamePages", false, "Rename pages stored by older versions under a non-canonical URL before crawling (reads every page)")
+	verifyPages := flag.Bool("verifyPages", false, "Remove corrupt stored pages before crawling so they're re-fetched (reads every page)")
+	flag.StringVar(&config.DNSServer, "dnsServer", config.DNSServer, "DNS server (host:port) used to resolve hostnames instead of the system resolver")
+	flag.DurationVar(&config.DialTimeout, "dialTimeout", config.DialTimeout, "Timeout for establishing a connection")
+	var resolve stringList
+	flag.Var(&resolve, "resolve", "Pin a host to an address as host=ip, may be repeated")
+	var hostHeaders stringList
+	flag.Var(&hostHeaders, "hostHeader", "Send this Host header to every host, or to one as host=vhost, may be repeated")
+	flag.Float64Var(&config.GlobalRPS, "globalRPS", config.GlobalRPS, "Maximum requests per second across all routines (0 is unlimited)")
+	flag.Float64Var(&config.PerHostRPS, "perHostRPS", config.PerHostRPS, "Maximum requests per second to any single host (0 is unlimited)")
+	flag.IntVar(&config.MaxPerHost, "maxPerHost", config.MaxPerHost, "Maximum requests in flight to any single host, counting the WordPress probes made alongside its crawl (0 is unlimited)")
+	flag.BoolVar(&config.SendReferer, "sendReferer", config.SendReferer, "Send the URL of the page a link was found on as the Referer header")
+	flag.BoolVar(&config.RecordSkips, "recordSkips", config.RecordSkips, "Record every skipped URL and the reason to skipped.jsonl in -pageDir")
+	flag.StringVar(&config.FailuresFile, "failuresFile", config.FailuresFile, "Record failed fetches and their category to this file as JSON lines")
+	flag.StringVar(&config.DiscoveredSink, "discoveredSink", config.DiscoveredSink, "Stream every enqueued URL once to this file as it's discovered, - for stdout")
+	flag.BoolVar(&config.OnlyNew, "onlyNew", config.OnlyNew, "Only enqueue URLs that aren't stored or already in the frontier and report new vs known URLs")
+	flag.DurationVar(&config.IdleTimeout, "idleTimeout", config.IdleTimeout, "Stop once the frontier has been empty and every routine idle this long (0 waits forever)")
+	flag.DurationVar(&config.MaxDuration, "maxDuration", config.MaxDuration, "Stop crawling after this long (0 crawls until interrupted)")
+	flag.StringVar(&config.DuplicateAlgo, "fingerprintAlgo", config.DuplicateAlgo, "Near-duplicate detection algorithm, shingle or simhash")
+	flag.IntVar(&config.MaxFingerprints, "maxFingerprints", config.MaxFingerprints, "Keep a MinHash sketch of at most this many shingle fingerprints per page (0 keeps all)")
+	flag.Float64Var(&config.DuplicateThreshold, "duplicateThreshold", config.DuplicateThreshold, "Similarity above which a page is considered a near-duplicate")
+	flag.IntVar(&config.SimHashDistance, "simhashDistance", config.SimHashDistance, "Maximum Hamming distance between the SimHashes of near-duplicates, replacing -duplicateThreshold with simhash (0 keeps it, the similarity is 1 - distance/64)")
+	flag.BoolVar(&config.LoadContentHashes, "loadContentHashes", config.LoadContentHashes, "Skip pages byte-identical to pages stored by earlier runs, not only this one")
+	flag.IntVar(&config.DuplicateWindow, "duplicateWindow", config.DuplicateWindow, "Number of recently stored pages per routine compared for near-duplicates")
+	flag.BoolVar(&config.StoreRefreshStubs, "storeRefreshStubs", config.StoreRefreshStubs, "Store pages that meta refresh to another URL as well as following them")
+	flag.BoolVar(&config.TraceTimings, "traceTimings", config.TraceTimings, "Log DNS, connect, TLS and time to first byte timings of every fetch at debug level (-verbose)")
+	flag.BoolVar(&config.FollowFeeds, "followFeeds", config.FollowFeeds, "Enqueue the posts listed in RSS and Atom feeds")
+	flag.BoolVar(&config.StoreFeeds, "storeFeeds", config.StoreFeeds, "Store the feed documents themselves when following feeds")
+	flag.StringVar(&config.MinTLS, "minTLS", config.MinTLS, "Minimum TLS version accepted, 1.0, 1.1, 1.2 or 1.3")
+	var pinCerts stringList
+	flag.Var(&pinCerts, "pinCert", "Pin a host to the hex SHA-256 of its leaf certificate as host=sha256, may be repeated")
+	flag.BoolVar(&config.LowercasePaths, "lowercasePaths", config.LowercasePaths, "Lowercase URL paths when canonicalizing (only for case-insensitive servers)")
+	flag.BoolVar(&config.WWWCanonicalization, "wwwCanonicalization", config.WWWCanonicalization, "Rewrite URLs to the www. or apex host a site permanently redirects to")
+	flag.BoolVar(&config.ForceHTTP1, "forceHTTP1", config.ForceHTTP1, "Never negotiate HTTP/2 with https hosts")
+	flag.BoolVar(&config.H2C, "h2c", config.H2C, "Speak cleartext HTTP/2 (h2c) to http:// URLs, for testing")
+	flag.BoolVar(&config.AllowPrivate, "allowPrivate", config.AllowPrivate, "Allow crawling loopback, private and link-local addresses")
+	flag.BoolVar(&config.Deterministic, "deterministic", config.Deterministic, "Crawl with one routine in a reproducible order without delays (for tests)")
+	flag.Int64Var(&config.MaxBodyBytes, "maxBodyBytes", config.MaxBodyBytes, "Skip pages larger than this many bytes (0 is unlimited)")
+	flag.BoolVar(&config.Compress, "compress", config.Compress, "Store pages gzipped as .json.gz")
+	flag.Int64Var(&config.MaxDiskBytes, "maxDiskBytes", config.MaxDiskBytes, "Stop the crawl once the pages written this run take more than this many bytes (0 is unlimited)")
+	flag.DurationVar(&config.CheckpointInterval, "checkpointInterval", config.CheckpointInterval, "How often to checkpoint the crawl's counters and flags to -pageDir (0 disables)")
+	resume := flag.Bool("resume", false, "Restore the counters and flags of the last checkpoint in -pageDir, flags given again override it")
+	flag.DurationVar(&config.StatsInterval, "statsInterval", config.StatsInterval, "How often to log crawl progress and stuck routines (0 disables)")
+	skipQueryParams := flag.String("skipQueryParams", strings.Join(config.SkipQueryParams, ","), "Comma-separated query parameters whose URLs are skipped, e.g. paged")
+	flag.Float64Var(&config.SampleRate, "sampleRate", config.SampleRate, "Fraction (0.0-1.0) of discovered links to enqueue, picked deterministically by URL hash")
+	flag.StringVar(&config.AcceptEncoding, "acceptEncoding", config.AcceptEncoding, "Accept-Encoding sent with crawl requests, only gzip, br and identity are supported (empty lets Go ask for gzip)")
+	flag.StringVar(&config.UserAgent, "userAgent", config.UserAgent, "User-Agent sent with crawl requests (defaults to Go's)")
+	userAgentFile := flag.String("userAgentFile", "", "File of per-host User-Agent overrides, one \"host user-agent\" per line")
+	flag.StringVar(&config.AcceptHeader, "accept", config.AcceptHeader, "Accept header sent with crawl requests (empty sends none)")
+	flag.DurationVar(&config.IdleBackoff, "idleBackoff", config.IdleBackoff, "How long a routine with nothing to crawl waits before checking again, doubling while it stays empty")
+	flag.DurationVar(&config.MaxIdleBackoff, "maxIdleBackoff", config.MaxIdleBackoff, "Longest wait of a routine with nothing to crawl, and so the longest before it notices new URLs")
+	flag.IntVar(&config.MaxURLLength, "maxURLLength", config.MaxURLLength, "Reject URLs longer than this many characters (0 allows any)")
+	flag.IntVar(&config.MaxRetries, "maxRetries", config.MaxRetries, "Number of times a URL answered 429 or 503 with a Retry-After is retried before giving up")
+	flag.IntVar(&config.MaxHostFailures, "maxHostFailures", config.MaxHostFailures, "Skip a host for the rest of the run after this many consecutive connection errors or 5xx responses (0 disables)")
+	flag.BoolVar(&config.RecordNon200, "recordNon200", config.RecordNon200, "Record the URL and status of non-200 responses to non200.jsonl in -pageDir")
+	flag.BoolVar(&config.RecordCrawlOrder, "recordCrawlOrder", config.RecordCrawlOrder, "Store each page's fetch latency in milliseconds and crawl sequence number")
+	flag.BoolVar(&config.StoreImages, "storeImages", config.StoreImages, "Store the absolute URLs of each page's images (img src and srcset), up to -maxLinks")
+	flag.BoolVar(&config.RFC3339Dates, "rfc3339", config.RFC3339Dates, "Store crawl timestamps as RFC3339 dates alongside epoch seconds")
+
+	// Arguments for sitemap generation
+	sitemapHost := flag.String("sitemapHost", "", "Generate a sitemap of stored pages for this host instead of crawling")
+	dedupe := flag.String("dedupe", "", "Find the near-duplicate pages (above -threshold) of this pageDir instead of crawling")
+	threshold := flag.Float64("threshold", 0, "Similarity above which -dedupe considers pages near-duplicates (defaults to -duplicateThreshold)")
+	keep := flag.String("keep", spider.KeepShortest, "Page of each near-duplicate cluster -dedupe keeps, shortest (URL) or earliest (crawl)")
+	quarantine := flag.String("quarantine", "", "Move the duplicates found by -dedupe here instead of deleting them")
+	confirm := flag.Bool("confirm", false, "Let -dedupe delete or quarantine duplicates instead of only reporting them")
+	merge := flag.String("merge", "", "Comma-separated pageDirs to merge into -out instead of crawling, keeping the latest copy of each page")
+	mergeOut := flag.String("out", "", "Location the pageDirs given to -merge are merged into")
+	sitemapOut := flag.String("sitemapOut", "sitemap.xml", "Location for the generated sitemap to be saved")
+	sitemapBaseURL := flag.String("sitemapBaseURL", "", "URL the sitemap files split off a sitemap index are served under (defaults to the root of -sitemapHost)")
+
+	// Arguments for the indexer
+	buildIndex := flag.String("buildIndex", "", "Index the stored pages and save the index to this file instead of crawling")
+	indexWorkers := flag.Int("indexWorkers", runtime.NumCPU(), "Number of routines reading and tokenizing pages for -buildIndex")
+	var stopwordsFiles stringList
+	flag.Var(&stopwordsFiles, "stopwordsFile", "Newline-delimited stopword file, may be repeated to merge languages (defaults to English)")
+	indexOnly := flag.Bool("indexOnly", false, "Add crawled pages to -index instead of storing them in -pageDir")
+
+	// Arguments for the search server
+	serve := flag.String("serve", "", "Serve /search over HTTP on this address (e.g. :8080) instead of crawling")
+	indexFile := flag.String("index", "index.json", "Index built with -buildIndex to search")
+	ranker := flag.String("ranker", indexer.BM25Ranker, "Ranking function, bm25 or tfidf")
+	bm25K1 := flag.Float64("bm25K1", indexer.DefaultK1, "BM25 term frequency saturation")
+	bm25B := flag.Float64("bm25B", indexer.DefaultB, "BM25 document length normalization (0.0-1.0)")
+	query := flag.String("query", "", "Print the results of this query against -index instead of crawling")
+	k := flag.Int("k", 10, "Number of results printed by -query")
+	fuzzy := flag.Int("fuzzy", 0, "Maximum edit distance of fuzzy matches for -query (0 disables)")
+	jsonOutput := flag.Bool("json", false, "Print -query results as JSON")
+
+	// Arguments for logging
+	quiet := flag.Bool("quiet", false, "Only log errors")
+	verbose := flag.Bool("verbose", false, "Also log debug messages, such as the -traceTimings timings")
+	jsonLogs := flag.Bool("jsonLogs", false, "Log JSON lines instead of text")
+	logFilePath := flag.String("logFile", "searchHouse.log", "File the log is appended to")
+	logMaxBytes := flag.Int64("logMaxBytes", 0, "Rotate the log to -logFile.1, .2 and so on once it would grow past this many bytes (0 never rotates)")
+	logBackups := flag.Int("logBackups", 5, "Number of rotated logs kept by -logMaxBytes, the oldest is deleted")
+
+	flag.Parse()
+
 	// Create a log file
-	logFile, err := os.OpenFile("searchHouse.log", os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	logFile, err := openRotatingFile(*logFilePath, *logMaxBytes, *logBackups)
 	if err != nil {
 		log.Fatalf("Failed to open log file: %v", err)
 	}
@@ -18,19 +178,480 @@ func main() {
 	// Set log output to the file
 	log.SetOutput(logFile)
 
-	// Arguments for spider
-	var isSpider bool
-	flag.BoolVar(&isSpider, "spider", false, "Run the spider")
-	numRoutines := flag.Int("numRoutines", 1, "Number of routines for spider to use")
-	pageDir := flag.String("pageDir", "pages", "Location for pages to be saved")
-	seed := flag.String("seed", "", "First page to start out crawling with")
-	maxLinks := flag.Int("maxLinks", 20, "Maximum number of links acceptable within a web page (memory usage)")
+	// Log through slog from here on, the log package is only
+	// left for fatal errors so they're logged at error level
+	handlerOptions := &slog.HandlerOptions{Level: slog.LevelInfo}
+	if *quiet && *verbose {
+		log.Fatal("-quiet and -verbose can't be combined")
+	}
+	if *quiet {
+		handlerOptions.Level = slog.LevelError
+	} else if *verbose {
+		handlerOptions.Level = slog.LevelDebug
+	}
+	var handler slog.Handler = slog.NewTextHandler(logFile, handlerOptions)
+	if *jsonLogs {
+		handler = slog.NewJSONHandler(logFile, handlerOptions)
+	}
+	slog.SetDefault(slog.New(handler))
+	slog.SetLogLoggerLevel(slog.LevelError)
 
-	flag.Parse()
+	var checkpoint *spider.Checkpoint
+	if *resume {
+		checkpoint, err = spider.ReadCheckpoint(config.PageDir)
+		if err != nil {
+			log.Fatalf("Failed to read checkpoint: %v", err)
+		}
+		err = applyCheckpointConfig(checkpoint.Config)
+		if err != nil {
+			log.Fatalf("Failed to restore checkpointed flags: %v", err)
+		}
+	}
+
+	if *dedupe != "" {
+		if *threshold == 0 {
+			*threshold = config.DuplicateThreshold
+		}
+		report, err := spider.PurgeDuplicates(*dedupe, spider.PurgeOptions{
+			Threshold:  *threshold,
+			Keep:       *keep,
+			Quarantine: *quarantine,
+			Confirm:    *confirm,
+		})
+		if err != nil {
+			log.Fatalf("Failed to dedupe pages: %v", err)
+		}
+		b, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			log.Fatalf("Failed to print dedupe report: %v", err)
+		}
+		fmt.Println(string(b))
+		if !*confirm {
+			fmt.Println("Nothing was purged, rerun with -confirm to purge the duplicates")
+		}
+		return
+	}
+
+	if *merge != "" {
+		if *mergeOut == "" {
+			log.Fatalln("-merge requires -out")
+		}
+		report, err := spider.MergePageDirs(strings.Split(*merge, ","), *mergeOut)
+		if err != nil {
+			log.Fatalf("Failed to merge page directories: %v", err)
+		}
+		fmt.Printf("Merged %d pages, skipped %d, resolved %d conflicts\n", report.Merged, report.Skipped, report.Conflicts)
+		return
+	}
+
+	if *sitemapHost != "" {
+		var err error
+		if *sitemapBaseURL != "" {
+			err = spider.GenerateSitemapWithBaseURL(config.PageDir, *sitemapHost, *sitemapOut, *sitemapBaseURL)
+		} else {
+			err = spider.GenerateSitemap(config.PageDir, *sitemapHost, *sitemapOut)
+		}
+		if err != nil {
+			log.Fatalf("Failed to generate sitemap: %v", err)
+		}
+	}
+
+	if *buildIndex != "" {
+		stopwords, err := loadStopwords(stopwordsFiles)
+		if err != nil {
+			log.Fatalf("Failed to read stopwords: %v", err)
+		}
+		idx, err := indexer.BuildIndex(config.PageDir, stopwords, *indexWorkers)
+		if err != nil {
+			log.Fatalf("Failed to build index: %v", err)
+		}
+		err = idx.Save(*buildIndex)
+		if err != nil {
+			log.Fatalf("Failed to save index: %v", err)
+		}
+		return
+	}
+
+	if *query != "" || *serve != "" {
+		idx, err := indexer.LoadIndex(*indexFile)
+		if err != nil {
+			log.Fatalf("Failed to load index: %v", err)
+		}
+		r, err := indexer.NewRanker(*ranker, *bm25K1, *bm25B)
+		if err != nil {
+			log.Fatalf("Invalid ranker: %v", err)
+		}
+		idx.SetRanker(r)
+		if *query != "" {
+			err = printQuery(idx, *query, indexer.SearchOptions{Size: *k, MaxEdits: *fuzzy}, *jsonOutput)
+			if err != nil {
+				log.Fatalf("Failed to print results: %v", err)
+			}
+			return
+		}
+		slog.Info("Serving documents", "documents", len(idx.Docs), "address", *serve)
+		log.Fatal(http.ListenAndServe(*serve, indexer.NewServer(idx)))
+	}
+
+	if *dumpFrontier {
+		err = spider.DumpFrontier("frontier.db", os.Stdout, *dumpSample)
+		if err != nil {
+			log.Fatalf("Failed to dump frontier: %v", err)
+		}
+		return
+	}
+
+	if *exportFrontier != "" {
+		s, err := spider.NewSpider(config)
+		if err != nil {
+			log.Fatalf("Invalid spider configuration: %v", err)
+		}
+		f, err := os.Create(*exportFrontier)
+		if err != nil {
+			log.Fatalf("Failed to create frontier export: %v", err)
+		}
+		err = s.ExportFrontier(f)
+		if err != nil {
+			log.Fatalf("Failed to export frontier: %v", err)
+		}
+		err = f.Close()
+		if err != nil {
+			log.Fatalf("Failed to export frontier: %v", err)
+		}
+		return
+	}
 
 	if isSpider {
-		// Frontier (pages.db) must be reset if numRoutines changes in between runs!
-		s := spider.NewSpider(*numRoutines, *pageDir, []string{*seed}, *maxLinks)
-		s.CrawlConcurrently()
+		// URLs frontier.db queued for other routines than their host
+		// hashes to with -numRoutines are moved when the crawl starts
+		seeds := make([]string, 0)
+		if *seed != "" {
+			seeds = append(seeds, *seed)
+		}
+		if *seedFile != "" {
+			fileSeeds, priorities, err := readSeedFile(*seedFile)
+			if err != nil {
+				log.Fatalf("Failed to read seed file: %v", err)
+			}
+			seeds = append(seeds, fileSeeds...)
+			config.SeedPriorities = priorities
+		}
+		hostConfigs := make(map[string]spider.HostConfig)
+		if *siteConfigFile != "" {
+			siteSeeds, configs, err := readSiteConfigFile(*siteConfigFile)
+			if err != nil {
+				log.Fatalf("Failed to read site config: %v", err)
+			}
+			seeds = append(seeds, siteSeeds...)
+			hostConfigs = configs
+		}
+		config.Seeds = seeds
+		config.HostConfigs = hostConfigs
+		if *userAgentFile != "" {
+			config.HostUserAgents, err = readUserAgentFile(*userAgentFile)
+			if err != nil {
+				log.Fatalf("Failed to read User-Agent file: %v", err)
+			}
+		}
+		config.HostOverrides = make(map[string]string)
+		for _, mapping := range resolve {
+			host, ip, found := strings.Cut(mapping, "=")
+			if !found {
+				log.Fatalf("Invalid -resolve mapping %q, expected host=ip", mapping)
+			}
+			config.HostOverrides[host] = ip
+		}
+		config.HostHeaders = make(map[string]string)
+		for _, mapping := range hostHeaders {
+			host, hostHeader, found := strings.Cut(mapping, "=")
+			if !found {
+				config.HostHeader = mapping
+				continue
+			}
+			config.HostHeaders[host] = hostHeader
+		}
+		config.PinnedCerts = make(map[string]string)
+		for _, mapping := range pinCerts {
+			host, fingerprint, found := strings.Cut(mapping, "=")
+			if !found {
+				log.Fatalf("Invalid -pinCert mapping %q, expected host=sha256", mapping)
+			}
+			config.PinnedCerts[host] = fingerprint
+		}
+		if len(excludePatterns) > 0 {
+			config.ExcludePatterns = excludePatterns
+		}
+		if len(bodyNoisePatterns) > 0 {
+			config.BodyNoisePatterns = bodyNoisePatterns
+		}
+		if len(paginationPatterns) > 0 {
+			config.PaginationPatterns = paginationPatterns
+		}
+		if len(trapPatterns) > 0 {
+			config.TrapPatterns = trapPatterns
+		}
+		if *languages != "" {
+			config.Languages = strings.Split(*languages, ",")
+		}
+		config.WordPressProbePaths = strings.Split(*wordpressProbePaths, ",")
+		config.SkipQueryParams = strings.Split(*skipQueryParams, ",")
+		config.CheckpointFlags = checkpointConfig()
+		var idx *indexer.Index
+		if *indexOnly {
+			idx, err = openIndex(*indexFile, stopwordsFiles)
+			if err != nil {
+				log.Fatalf("Failed to open index: %v", err)
+			}
+			var idxMu sync.Mutex
+			config.IndexSink = func(page *common.WebPage) error {
+				idxMu.Lock()
+				defer idxMu.Unlock()
+				idx.AddDocument(page)
+				return nil
+			}
+			// Saved with every checkpoint and when the crawl ends
+			config.IndexFlush = func() error {
+				idxMu.Lock()
+				defer idxMu.Unlock()
+				return idx.Save(*indexFile)
+			}
+			for _, doc := range idx.Docs {
+				config.IndexedURLs = append(config.IndexedURLs, doc.Url)
+			}
+		}
+		s, err := spider.NewSpider(config)
+		if err != nil {
+			log.Fatalf("Invalid spider configuration: %v", err)
+		}
+		if *importFrontier != "" {
+			f, err := os.Open(*importFrontier)
+			if err != nil {
+				log.Fatalf("Failed to open frontier import: %v", err)
+			}
+			err = s.ImportFrontier(f)
+			f.Close()
+			if err != nil {
+				log.Fatalf("Failed to import frontier: %v", err)
+			}
+		}
+		if *renamePages {
+			err = s.RenameStoredPages()
+			if err != nil {
+				log.Fatalf("Failed to rename stored pages: %v", err)
+			}
+		}
+		if *verifyPages {
+			err = s.VerifyStoredPages()
+			if err != nil {
+				log.Fatalf("Failed to verify stored pages: %v", err)
+			}
+		}
+		if checkpoint != nil {
+			s.Restore(checkpoint)
+		}
+		// Interrupting the crawl lets routines finish their current fetch
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		handlePauseSignals(s)
+		s.CrawlConcurrently(ctx)
+		stop()
+	}
+}
+
+func loadStopwords(files []string) (indexer.Stopwords, error) {
+	if len(files) == 0 {
+		return indexer.DefaultStopwords(), nil
+	}
+	return indexer.LoadStopwords(files...)
+}
+
+func openIndex(path string, stopwordsFiles []string) (*indexer.Index, error) {
+	// Continue the index left by a previous -indexOnly
+	// crawl, or start a new one if there isn't any
+	if _, err := os.Stat(path); err == nil {
+		return indexer.LoadIndex(path)
+	}
+	stopwords, err := loadStopwords(stopwordsFiles)
+	if err != nil {
+		return nil, err
+	}
+	return indexer.NewIndex(stopwords), nil
+}
+
+func printQuery(idx *indexer.Index, query string, opts indexer.SearchOptions, jsonOutput bool) error {
+	// Print the results of a query to stdout, either as the
+	// JSON the server responds with or one result per block
+	if jsonOutput {
+		response := idx.Query(query, opts, indexer.DefaultPreTag, indexer.DefaultPostTag)
+		return json.NewEncoder(os.Stdout).Encode(response)
+	}
+	// Plain text snippets are easier to grep than highlighted ones
+	response := idx.Query(query, opts, "", "")
+	fmt.Printf("%d results for %q\n", response.Total, query)
+	for _, result := range response.Results {
+		_, err := fmt.Printf("\n%.4f %s\n%s\n", result.Score, result.Url, result.Snippet)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// siteConfig is a seed of the site config file
+// along with the settings overridden for its host
+
+type siteConfig struct {
+	Seed         string   `json:"seed"`
+	MaxPages     int      `json:"maxPages"`
+	MaxDepth     int      `json:"maxDepth"`
+	CrawlDelay   string   `json:"crawlDelay"`
+	PathPrefixes []string `json:"pathPrefixes"`
+}
+
+func readSiteConfigFile(path string) ([]string, map[string]spider.HostConfig, error) {
+	// Read a JSON array of site configs, returning their
+	// seeds and the HostConfig of each seed's host
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	var sites []siteConfig
+	err = json.Unmarshal(b, &sites)
+	if err != nil {
+		return nil, nil, err
+	}
+	seeds := make([]string, 0, len(sites))
+	configs := make(map[string]spider.HostConfig)
+	for _, site := range sites {
+		parsedUrl, err := url.Parse(site.Seed)
+		if err != nil {
+			return nil, nil, err
+		}
+		config := spider.HostConfig{MaxPages: site.MaxPages, MaxDepth: site.MaxDepth, PathPrefixes: site.PathPrefixes}
+		if site.CrawlDelay != "" {
+			delay, err := time.ParseDuration(site.CrawlDelay)
+			if err != nil {
+				return nil, nil, err
+			}
+			config.CrawlDelay = &delay
+		}
+		seeds = append(seeds, site.Seed)
+		configs[parsedUrl.Host] = config
+	}
+	return seeds, configs, nil
+}
+
+// stringList is a flag that may be repeated to build a list
+
+type stringList []string
+
+func (sl *stringList) String() string {
+	return strings.Join(*sl, ",")
+}
+
+func (sl *stringList) Set(value string) error {
+	*sl = append(*sl, value)
+	return nil
+}
+
+func checkpointConfig() map[string][]string {
+	// The flags set on the command line, except -resume itself
+	config := make(map[string][]string)
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "resume" {
+			return
+		}
+		if values, ok := f.Value.(*stringList); ok {
+			config[f.Name] = *values
+		} else {
+			config[f.Name] = []string{f.Value.String()}
+		}
+	})
+	return config
+}
+
+func applyCheckpointConfig(config map[string][]string) error {
+	// Set the checkpointed flags that weren't given again
+	given := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+	for name, values := range config {
+		if given[name] {
+			continue
+		}
+		if flag.Lookup(name) == nil {
+			// Flags removed since the checkpoint was written
+			slog.Warn("Ignoring unknown checkpointed flag", "flag", name)
+			continue
+		}
+		for _, value := range values {
+			err := flag.Set(name, value)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func readUserAgentFile(path string) (map[string]string, error) {
+	// Read a host and the User-Agent sent to it per line,
+	// ignoring blank lines and lines starting with #
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	userAgents := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		host, userAgent, _ := strings.Cut(strings.ReplaceAll(line, "\t", " "), " ")
+		userAgent = strings.TrimSpace(userAgent)
+		if userAgent == "" {
+			return nil, fmt.Errorf("expected \"host user-agent\", got %q", line)
+		}
+		userAgents[host] = userAgent
+	}
+	return userAgents, scanner.Err()
+}
+
+func readSeedFile(path string) ([]string, map[string]float64, error) {
+	// Read one URL per line, optionally followed by its
+	// priority ("https://site.com 10"), ignoring blank
+	// lines and lines starting with #
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	seeds := make([]string, 0)
+	priorities := make(map[string]float64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 2 {
+			return nil, nil, fmt.Errorf("invalid seed line %q, expected \"url [priority]\"", line)
+		}
+		if len(fields) == 2 {
+			priority, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid priority of seed %s: %w", fields[0], err)
+			}
+			// Priorities end up in SQL, where Inf and NaN aren't numbers
+			if math.IsInf(priority, 0) || math.IsNaN(priority) {
+				return nil, nil, fmt.Errorf("invalid priority of seed %s: %s is not finite", fields[0], fields[1])
+			}
+			priorities[fields[0]] = priority
+		}
+		seeds = append(seeds, fields[0])
 	}
+	return seeds, priorities, scanner.Err()
 }
@@ -0,0 +1,25 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"os/signal"
+	"searchHouse/spider"
+	"syscall"
+)
+
+func handlePauseSignals(s *spider.SearchHouseSpider) {
+	// SIGUSR1 pauses the crawl and SIGUSR2 resumes it
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range signals {
+			if sig == syscall.SIGUSR1 {
+				s.Pause()
+			} else {
+				s.Resume()
+			}
+		}
+	}()
+}
@@ -0,0 +1,9 @@
+//go:build windows
+
+package main
+
+import "searchHouse/spider"
+
+func handlePauseSignals(s *spider.SearchHouseSpider) {
+	// SIGUSR1 and SIGUSR2 don't exist on Windows
+}
@@ -0,0 +1,67 @@
+package spider
+
+import (
+	"searchHouse/common"
+	"strings"
+)
+
+func (s *SearchHouseSpider) setLanguages(languages []string) {
+	// Only follow the translations of a page, declared with
+	// hreflang, in one of these languages. A language such as
+	// en also matches its regional variants like en-gb. The
+	// links to the other translations are no longer followed
+	s.languages = nil
+	for _, language := range languages {
+		if language = strings.ToLower(strings.TrimSpace(language)); language != "" {
+			s.languages = append(s.languages, language)
+		}
+	}
+}
+
+func (s *SearchHouseSpider) setFollowAlternates(enabled bool) {
+	// Enqueue the hreflang translations of every stored page
+	// in the accepted languages even when nothing links to them
+	s.followAlternates = enabled
+}
+
+func (s *SearchHouseSpider) findAlternates(page *common.WebPage) common.Alternates {
+	// The page's translations rewritten like the URLs it's compared to
+	alternates := page.FindAlternates()
+	for lang, alternate := range alternates {
+		alternates[lang] = s.canonicalize(alternate)
+	}
+	return alternates
+}
+
+func (s *SearchHouseSpider) languageAccepted(hreflang string) bool {
+	// x-default is the fallback for unmatched languages, not one of them
+	if len(s.languages) == 0 || hreflang == "x-default" {
+		return true
+	}
+	for _, language := range s.languages {
+		if hreflang == language || strings.HasPrefix(hreflang, language+"-") {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *SearchHouseSpider) applyAlternates(page *common.WebPage, links *StringSet) {
+	// Drop the links to translations in other languages
+	// and add those in accepted ones if configured to
+	for lang, alternate := range page.Alternates {
+		if alternate == page.Url {
+			continue
+		}
+		if !s.languageAccepted(lang) {
+			if links.Contains(alternate) {
+				links.Remove(alternate)
+				s.recordSkip(alternate, "hreflang "+lang+" not in languages")
+			}
+			continue
+		}
+		if s.followAlternates {
+			links.Add(alternate)
+		}
+	}
+}
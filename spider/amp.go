@@ -0,0 +1,77 @@
+package spider
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"searchHouse/common"
+)
+
+// How AMP versions of pages (<link rel="amphtml">, usually
+// /amp/ on WordPress) are handled. AMPSkip never stores them,
+// following their canonical page instead, while AMPStore
+// stores both, linked through their AmpUrl and AmpOf
+const (
+	AMPSkip  = "skip"
+	AMPStore = "store"
+)
+
+func (s *SearchHouseSpider) setAMPMode(mode string) error {
+	if mode != AMPSkip && mode != AMPStore {
+		return fmt.Errorf("unknown AMP mode %q", mode)
+	}
+	s.ampMode = mode
+	return nil
+}
+
+func (s *SearchHouseSpider) findAMP(page *common.WebPage) {
+	// Record the page's AMP version or, if it's an AMP
+	// page itself, the canonical page it's a version of
+	if amp := page.FindAmpHTML(); amp != "" && s.canonicalize(amp) != page.Url {
+		page.AmpUrl = s.canonicalize(amp)
+	}
+	if !page.IsAMP() {
+		return
+	}
+	canonical := page.FindCanonical()
+	if canonical == "" {
+		return
+	}
+	base, err := url.Parse(page.Url)
+	if err != nil {
+		return
+	}
+	target, err := base.Parse(canonical)
+	if err != nil || (target.Scheme != "http" && target.Scheme != "https") {
+		return
+	}
+	if canonical = s.canonicalize(target.String()); canonical != page.Url {
+		page.AmpOf = canonical
+	}
+}
+
+func (s *SearchHouseSpider) skipAMP(entry FrontierEntry, page *common.WebPage) bool {
+	// Whether the page is an AMP version that isn't stored,
+	// in which case its canonical page is enqueued instead
+	if page.AmpOf == "" || s.ampMode != AMPSkip {
+		return false
+	}
+	slog.Info("spider - Skipping AMP version of page", "url", page.Url, "canonical", page.AmpOf)
+	s.recordSkip(page.Url, "AMP version of "+page.AmpOf)
+	if !s.noFollow && s.urlValid(page.AmpOf) {
+		var canonical StringSet
+		canonical.Add(page.AmpOf)
+		s.enqueueLinks(entry, canonical)
+	}
+	return true
+}
+
+func (s *SearchHouseSpider) applyAMP(page *common.WebPage, links *StringSet) {
+	// Drop the link to the page's AMP version when AMP
+	// pages are skipped, saving a fetch of a duplicate
+	if page.AmpUrl == "" || s.ampMode != AMPSkip || !links.Contains(page.AmpUrl) {
+		return
+	}
+	links.Remove(page.AmpUrl)
+	s.recordSkip(page.AmpUrl, "AMP version of "+page.Url)
+}
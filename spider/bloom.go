@@ -0,0 +1,106 @@
+package spider
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"searchHouse/common"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// BloomFilter is a set of uint64 keys that may report keys it doesn't
+// contain (at its false positive rate) but never misses one it does
+
+type BloomFilter struct {
+	mu     sync.Mutex
+	bits   []uint64
+	m      uint64
+	hashes int
+}
+
+func NewBloomFilter(expected int, falsePositiveRate float64) *BloomFilter {
+	// Size the filter for the expected number of keys, see
+	// https://en.wikipedia.org/wiki/Bloom_filter#Optimal_number_of_hash_functions
+	expected = max(expected, 1)
+	m := uint64(math.Ceil(-float64(expected) * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2)))
+	m = max(m, 64)
+	hashes := max(int(math.Round(float64(m)/float64(expected)*math.Ln2)), 1)
+	return &BloomFilter{bits: make([]uint64, (m+63)/64), m: m, hashes: hashes}
+}
+
+func (bf *BloomFilter) Add(key uint64) {
+	bf.mu.Lock()
+	defer bf.mu.Unlock()
+	h1, h2 := bloomHashes(key)
+	for i := 0; i < bf.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % bf.m
+		bf.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+func (bf *BloomFilter) MayContain(key uint64) bool {
+	bf.mu.Lock()
+	defer bf.mu.Unlock()
+	h1, h2 := bloomHashes(key)
+	for i := 0; i < bf.hashes; i++ {
+		bit := (h1 + uint64(i)*h2) % bf.m
+		if bf.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func bloomHashes(key uint64) (uint64, uint64) {
+	// Derive the two hashes of double hashing by mixing the
+	// key with splitmix64, the odd second hash never repeats
+	// a bit before cycling through all of them
+	mix := func(z uint64) uint64 {
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		return z ^ (z >> 31)
+	}
+	return mix(key), mix(key+0x9e3779b97f4a7c15) | 1
+}
+
+func (s *SearchHouseSpider) setDownloadedBloomFilter(expected int, falsePositiveRate float64) error {
+	// Check a bloom filter of the stored pages before the
+	// filesystem, so most URLs that weren't downloaded are
+	// ruled out without a stat. Only positives hit the disk
+	if expected <= 0 {
+		s.downloaded = nil
+		return nil
+	}
+	// A rate of 0 needs an infinite filter and 1 an empty one
+	if !(falsePositiveRate > 0 && falsePositiveRate < 1) {
+		return fmt.Errorf("bloom filter false positive rate %v is not between 0 and 1", falsePositiveRate)
+	}
+	s.downloaded = NewBloomFilter(expected, falsePositiveRate)
+	return nil
+}
+
+func (s *SearchHouseSpider) loadDownloaded() {
+	// Add the pages already in the working directory, which
+	// are named after the hash the filter is keyed by
+	if s.downloaded == nil {
+		return
+	}
+	entries, err := os.ReadDir(s.workingDirectory)
+	if err != nil {
+		slog.Error("spider - Error listing stored pages", "err", err)
+		return
+	}
+	for _, entry := range entries {
+		if !common.IsStoredPageName(entry.Name()) {
+			continue
+		}
+		base := strings.TrimSuffix(strings.TrimSuffix(entry.Name(), common.CompressedPageExt), common.PageExt)
+		hash, err := strconv.ParseUint(base, 10, 64)
+		if err == nil {
+			s.downloaded.Add(hash)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package spider
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const checkpointFileName = "checkpoint.json"
+
+// Checkpoint is the crawl state that doesn't persist on its own,
+// frontier.db and the stored pages already survive a crash. Config
+// holds the command-line flags of the run so a resumed crawl can
+// reuse them, repeatable flags have one value per occurrence
+
+type Checkpoint struct {
+	Time      string              `json:"time"`
+	Seeds     []string            `json:"seeds"`
+	Stats     CrawlStats          `json:"stats"`
+	HostPages map[string]int      `json:"hostPages"`
+	Config    map[string][]string `json:"config"`
+}
+
+func ReadCheckpoint(workingDirectory string) (*Checkpoint, error) {
+	b, err := os.ReadFile(filepath.Join(workingDirectory, checkpointFileName))
+	if err != nil {
+		return nil, err
+	}
+	var checkpoint Checkpoint
+	err = json.Unmarshal(b, &checkpoint)
+	if err != nil {
+		return nil, err
+	}
+	return &checkpoint, nil
+}
+
+func (s *SearchHouseSpider) setCheckpoints(interval time.Duration, config map[string][]string) {
+	// Write checkpoint.json to the working directory every
+	// interval and when the crawl ends, 0 disables checkpoints
+	s.checkpointInterval = interval
+	s.checkpointConfig = config
+}
+
+func (s *SearchHouseSpider) Restore(checkpoint *Checkpoint) {
+	// Carry the counters of a checkpointed crawl over to this one
+	s.stats.restore(checkpoint.Stats)
+	s.hostConfigs.restoreStored(checkpoint.HostPages)
+	slog.Info("spider - Resuming from checkpoint", "time", checkpoint.Time, "stored", checkpoint.Stats.PagesStored)
+}
+
+func (s *SearchHouseSpider) checkpointPeriodically(ctx context.Context) {
+	ticker := time.NewTicker(s.checkpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.flushIndex()
+		err := s.writeCheckpoint()
+		if err != nil {
+			slog.Error("spider - Error writing checkpoint", "err", err)
+		}
+	}
+}
+
+func (s *SearchHouseSpider) writeCheckpoint() error {
+	// Write to a temporary file and rename it over the previous
+	// checkpoint, so a crash mid-write leaves the previous one
+	b, err := json.MarshalIndent(Checkpoint{
+		Time:      time.Now().UTC().Format(time.RFC3339),
+		Seeds:     s.seeds,
+		Stats:     s.stats.Snapshot(),
+		HostPages: s.hostConfigs.storedSnapshot(),
+		Config:    s.checkpointConfig,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(s.workingDirectory, ".tmp-checkpoint-*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(s.workingDirectory, checkpointFileName))
+}
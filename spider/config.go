@@ -0,0 +1,296 @@
+package spider
+
+import (
+	"fmt"
+	"time"
+)
+
+// Config is everything a crawl is configured with. Zero values
+// are meaningful (a CrawlDelay of 0 disables politeness, nil
+// ExcludePatterns exclude nothing), so start from DefaultConfig
+// and change what's needed. The functions are left out of the
+// JSON logged at startup and written to the run manifest, and
+// so are the per-host User-Agents, addresses and Host headers,
+// which are only logged at debug level
+
+type Config struct {
+	NumRoutines int      `json:"numRoutines"`
+	PageDir     string   `json:"pageDir"`
+	Seeds       []string `json:"seeds"`
+	MaxLinks    int      `json:"maxLinks"`
+
+	// Called with every page stored, optionally on its own goroutine
+	OnPageStored      OnPageStored `json:"-"`
+	OnPageStoredAsync bool         `json:"onPageStoredAsync"`
+	// Priority of enqueued URLs, DepthScorer when nil
+	Scorer URLScorer `json:"-"`
+	// Pages stored, AcceptHTML when nil
+	Accept AcceptFunc `json:"-"`
+	// Hash of file names, routines and content, FNVHash when nil
+	HashFunc HashFunc `json:"-"`
+	// Pages are handed to IndexSink instead of being written to
+	// PageDir when set, IndexedURLs count as already downloaded.
+	// IndexFlush saves what the sink was handed so far, with every
+	// checkpoint and before the content hashes are saved
+	IndexSink   PageSink     `json:"-"`
+	IndexFlush  func() error `json:"-"`
+	IndexedURLs []string     `json:"-"`
+
+	// Scope
+	HostConfigs          map[string]HostConfig `json:"hostConfigs,omitempty"`
+	SeedPriorities       map[string]float64    `json:"seedPriorities,omitempty"`
+	NoFollow             bool                  `json:"noFollow"`
+	OnlyNew              bool                  `json:"onlyNew"`
+	SameHostAsSeed       bool                  `json:"sameHostAsSeed"`
+	IncludeSubdomains    bool                  `json:"includeSubdomains"`
+	FollowExternalOneHop bool                  `json:"followExternalOneHop"`
+	PathPrefixes         []string              `json:"pathPrefixes,omitempty"`
+	Languages            []string              `json:"languages,omitempty"`
+	FollowAlternates     bool                  `json:"followAlternates"`
+	AMPMode              string                `json:"ampMode"`
+	FollowFeeds          bool                  `json:"followFeeds"`
+	StoreFeeds           bool                  `json:"storeFeeds"`
+	StoreRefreshStubs    bool                  `json:"storeRefreshStubs"`
+	SampleRate           float64               `json:"sampleRate"`
+	ProbeSeeds           bool                  `json:"probeSeeds"`
+	ProbeTimeout         time.Duration         `json:"probeTimeout"`
+	MaxDuration          time.Duration         `json:"maxDuration"`
+	IdleTimeout          time.Duration         `json:"idleTimeout"`
+	IdleBackoff          time.Duration         `json:"idleBackoff"`
+	MaxIdleBackoff       time.Duration         `json:"maxIdleBackoff"`
+	Deterministic        bool                  `json:"deterministic"`
+
+	// Filters
+	RequireWordPress    bool     `json:"requireWordPress"`
+	WordPressProbePaths []string `json:"wordpressProbePaths"`
+	ExcludePatterns     []string `json:"excludePatterns"`
+	TrapPatterns        []string `json:"trapPatterns"`
+	TrapThreshold       int      `json:"trapThreshold"`
+	PaginationPatterns  []string `json:"paginationPatterns"`
+	MaxPaginationDepth  int      `json:"maxPaginationDepth"`
+	SkipQueryParams     []string `json:"skipQueryParams"`
+	MaxURLLength        int      `json:"maxURLLength"`
+	MaxBodyBytes        int64    `json:"maxBodyBytes"`
+	AllowPrivate        bool     `json:"allowPrivate"`
+	WWWCanonicalization bool     `json:"wwwCanonicalization"`
+	LowercasePaths      bool     `json:"lowercasePaths"`
+
+	// Politeness
+	CrawlDelay      time.Duration `json:"crawlDelay"`
+	MaxCrawlDelay   time.Duration `json:"maxCrawlDelay"`
+	GlobalRPS       float64       `json:"globalRPS"`
+	PerHostRPS      float64       `json:"perHostRPS"`
+	MaxPerHost      int           `json:"maxPerHost"`
+	MaxHostFailures int           `json:"maxHostFailures"`
+	MaxRetries      int           `json:"maxRetries"`
+
+	// Requests
+	UserAgent           string            `json:"userAgent"`
+	HostUserAgents      map[string]string `json:"-"`
+	AcceptHeader        string            `json:"accept"`
+	AcceptEncoding      string            `json:"acceptEncoding"`
+	SendReferer         bool              `json:"sendReferer"`
+	RecordRedirects     bool              `json:"recordRedirects"`
+	MaxRedirects        int               `json:"maxRedirects"`
+	DNSServer           string            `json:"dnsServer"`
+	DialTimeout         time.Duration     `json:"dialTimeout"`
+	HostOverrides       map[string]string `json:"-"`
+	HostHeader          string            `json:"-"`
+	HostHeaders         map[string]string `json:"-"`
+	MinTLS              string            `json:"minTLS"`
+	PinnedCerts         map[string]string `json:"pinnedCerts,omitempty"`
+	MaxIdleConnsPerHost int               `json:"maxIdleConnsPerHost"`
+	IdleConnTimeout     time.Duration     `json:"idleConnTimeout"`
+	ForceHTTP1          bool              `json:"forceHTTP1"`
+	H2C                 bool              `json:"h2c"`
+	TraceTimings        bool              `json:"traceTimings"`
+
+	// Duplicates
+	DuplicateAlgo      string  `json:"fingerprintAlgo"`
+	DuplicateThreshold float64 `json:"duplicateThreshold"`
+	// Hamming distance of SimHash near-duplicates, replaces
+	// DuplicateThreshold with SimHashAlgo when over 0
+	SimHashDistance   int      `json:"simhashDistance"`
+	DuplicateWindow   int      `json:"duplicateWindow"`
+	MaxFingerprints   int      `json:"maxFingerprints"`
+	BodyNoisePatterns []string `json:"bodyNoisePatterns"`
+	LoadContentHashes bool     `json:"loadContentHashes"`
+	BloomExpected     int      `json:"bloomExpected"`
+	BloomFPRate       float64  `json:"bloomFPRate"`
+
+	// Output
+	Compress           bool          `json:"compress"`
+	MaxDiskBytes       int64         `json:"maxDiskBytes"`
+	RFC3339Dates       bool          `json:"rfc3339"`
+	RecordCrawlOrder   bool          `json:"recordCrawlOrder"`
+	StoreImages        bool          `json:"storeImages"`
+	RecordNon200       bool          `json:"recordNon200"`
+	RecordSkips        bool          `json:"recordSkips"`
+	FailuresFile       string        `json:"failuresFile"`
+	DiscoveredSink     string        `json:"discoveredSink"`
+	StatsInterval      time.Duration `json:"statsInterval"`
+	CheckpointInterval time.Duration `json:"checkpointInterval"`
+	// Flags given on the command line, saved with checkpoints
+	// so -resume can restore them
+	CheckpointFlags map[string][]string `json:"-"`
+}
+
+func DefaultConfig() Config {
+	return Config{
+		NumRoutines:         1,
+		PageDir:             "pages",
+		MaxLinks:            20,
+		AMPMode:             AMPSkip,
+		SampleRate:          1,
+		ProbeTimeout:        defaultProbeTimeout,
+		IdleBackoff:         defaultIdleBackoff,
+		MaxIdleBackoff:      defaultMaxIdleBackoff,
+		RequireWordPress:    true,
+		WordPressProbePaths: DefaultWordPressProbePaths,
+		ExcludePatterns:     DefaultExcludePatterns,
+		TrapPatterns:        DefaultTrapPatterns,
+		TrapThreshold:       100,
+		PaginationPatterns:  DefaultPaginationPatterns,
+		SkipQueryParams:     []string{"replytocom"},
+		MaxURLLength:        defaultMaxURLLength,
+		MaxBodyBytes:        defaultMaxBodyBytes,
+		WWWCanonicalization: true,
+		CrawlDelay:          defaultCrawlDelay,
+		MaxCrawlDelay:       defaultMaxCrawlDelay,
+		MaxHostFailures:     10,
+		MaxRetries:          defaultMaxRetries,
+		AcceptHeader:        DefaultAccept,
+		AcceptEncoding:      DefaultAcceptEncoding,
+		MaxRedirects:        defaultMaxRedirects,
+		DialTimeout:         defaultDialTimeout,
+		MaxIdleConnsPerHost: defaultMaxIdleConnsPerHost,
+		IdleConnTimeout:     defaultIdleConnTimeout,
+		DuplicateAlgo:       ShingleAlgo,
+		DuplicateThreshold:  defaultDuplicateThreshold,
+		DuplicateWindow:     defaultDuplicateWindow,
+		BodyNoisePatterns:   DefaultBodyNoisePatterns,
+		BloomFPRate:         0.01,
+		StatsInterval:       time.Minute,
+		CheckpointInterval:  5 * time.Minute,
+	}
+}
+
+func (s *SearchHouseSpider) configure(config Config) error {
+	// Apply every option of config. Options changing how URLs
+	// are canonicalized come before the ones canonicalizing URLs
+	s.setHostConfigs(config.HostConfigs)
+	s.setOnPageStoredAsync(config.OnPageStoredAsync)
+	s.setHashFunc(config.HashFunc)
+	s.setNoFollow(config.NoFollow)
+	s.setOnlyNew(config.OnlyNew)
+	s.setSameHostAsSeed(config.SameHostAsSeed)
+	s.setIncludeSubdomains(config.IncludeSubdomains)
+	s.setFollowExternalOneHop(config.FollowExternalOneHop)
+	s.setPathPrefixes(config.PathPrefixes)
+	s.setLanguages(config.Languages)
+	s.setFollowAlternates(config.FollowAlternates)
+	err := s.setAMPMode(config.AMPMode)
+	if err != nil {
+		return err
+	}
+	s.setFeeds(config.FollowFeeds, config.StoreFeeds)
+	s.setStoreRefreshStubs(config.StoreRefreshStubs)
+	err = s.setSampleRate(config.SampleRate)
+	if err != nil {
+		return err
+	}
+	s.setSeedProbe(config.ProbeSeeds, config.ProbeTimeout)
+	s.setMaxDuration(config.MaxDuration)
+	s.setIdleTimeout(config.IdleTimeout)
+	s.setIdleBackoff(config.IdleBackoff, config.MaxIdleBackoff)
+
+	s.setRequireWordPress(config.RequireWordPress)
+	s.setWordPressProbePaths(config.WordPressProbePaths)
+	err = s.setExcludePatterns(config.ExcludePatterns)
+	if err != nil {
+		return fmt.Errorf("invalid exclude pattern: %w", err)
+	}
+	err = s.setTrapDetection(config.TrapPatterns, config.TrapThreshold)
+	if err != nil {
+		return fmt.Errorf("invalid trap pattern: %w", err)
+	}
+	err = s.setPagination(config.PaginationPatterns, config.MaxPaginationDepth)
+	if err != nil {
+		return fmt.Errorf("invalid pagination pattern: %w", err)
+	}
+	s.setSkipQueryParams(config.SkipQueryParams)
+	s.setMaxURLLength(config.MaxURLLength)
+	s.setMaxBodyBytes(config.MaxBodyBytes)
+	s.setAllowPrivate(config.AllowPrivate)
+	s.setWWWCanonicalization(config.WWWCanonicalization)
+	s.setLowercasePaths(config.LowercasePaths)
+
+	s.setCrawlDelay(config.CrawlDelay)
+	s.setMaxCrawlDelay(config.MaxCrawlDelay)
+	s.setRateLimits(config.GlobalRPS, config.PerHostRPS)
+	s.setMaxPerHost(config.MaxPerHost)
+	s.setMaxHostFailures(config.MaxHostFailures)
+	s.setMaxRetries(config.MaxRetries)
+	// After the crawl delay, which it overrides
+	s.setDeterministic(config.Deterministic)
+
+	s.setUserAgents(config.UserAgent, config.HostUserAgents)
+	s.setHostHeaders(config.HostHeader, config.HostHeaders)
+	s.setAcceptHeader(config.AcceptHeader)
+	err = s.setAcceptEncoding(config.AcceptEncoding)
+	if err != nil {
+		return err
+	}
+	s.setSendReferer(config.SendReferer)
+	s.setRedirects(config.RecordRedirects, config.MaxRedirects)
+	s.setDialer(config.DNSServer, config.DialTimeout, config.HostOverrides)
+	err = s.setTLS(config.MinTLS, config.PinnedCerts)
+	if err != nil {
+		return fmt.Errorf("invalid TLS settings: %w", err)
+	}
+	s.setConnectionReuse(config.MaxIdleConnsPerHost, config.IdleConnTimeout)
+	s.setProtocols(config.ForceHTTP1, config.H2C)
+	s.setTraceTimings(config.TraceTimings)
+
+	err = s.setDuplicateDetection(config.DuplicateAlgo, config.DuplicateThreshold, config.SimHashDistance)
+	if err != nil {
+		return err
+	}
+	s.setDuplicateWindow(config.DuplicateWindow)
+	s.setMaxFingerprints(config.MaxFingerprints)
+	err = s.setBodyNoisePatterns(config.BodyNoisePatterns)
+	if err != nil {
+		return fmt.Errorf("invalid body noise pattern: %w", err)
+	}
+	s.setLoadContentHashes(config.LoadContentHashes)
+	err = s.setDownloadedBloomFilter(config.BloomExpected, config.BloomFPRate)
+	if err != nil {
+		return err
+	}
+
+	s.setCompress(config.Compress)
+	s.setMaxDiskBytes(config.MaxDiskBytes)
+	s.setRFC3339Dates(config.RFC3339Dates)
+	s.setRecordCrawlOrder(config.RecordCrawlOrder)
+	s.setStoreImages(config.StoreImages)
+	s.setRecordNon200(config.RecordNon200)
+	s.setRecordSkips(config.RecordSkips)
+	if config.FailuresFile != "" {
+		err = s.setFailuresFile(config.FailuresFile)
+		if err != nil {
+			return fmt.Errorf("failed to open failures file: %w", err)
+		}
+	}
+	if config.DiscoveredSink != "" {
+		err = s.setDiscoveredSink(config.DiscoveredSink)
+		if err != nil {
+			return fmt.Errorf("failed to open discovered URLs sink: %w", err)
+		}
+	}
+	s.setStatsInterval(config.StatsInterval)
+	s.setCheckpoints(config.CheckpointInterval, config.CheckpointFlags)
+	// Last so the indexed URLs are canonicalized
+	// with the options configured above
+	s.setIndexOnly(config.IndexSink, config.IndexFlush, config.IndexedURLs)
+	return nil
+}
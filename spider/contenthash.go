@@ -0,0 +1,126 @@
+package spider
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+const contentHashesFileName = "content-hashes.bin"
+
+// contentHashes is the set of hashes of the bodies stored so far,
+// used to skip pages byte-identical to one already stored. The
+// hashes are appended to a file in the working directory as
+// little-endian uint64s so later runs can load them. Deferred
+// hashes are only appended by persist, once the pages they
+// belong to are saved elsewhere, e.g. in index-only mode
+
+type contentHashes struct {
+	mu       sync.Mutex
+	seen     map[uint64]bool
+	f        *os.File
+	loaded   bool
+	deferred bool
+	pending  []uint64
+}
+
+func openContentHashes(path string, load bool) (*contentHashes, error) {
+	ch := &contentHashes{seen: make(map[uint64]bool), loaded: load}
+	if load {
+		b, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		// A crash mid-append can leave a partial hash at the end
+		for i := 0; i+8 <= len(b); i += 8 {
+			ch.seen[binary.LittleEndian.Uint64(b[i:])] = true
+		}
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return nil, err
+	}
+	ch.f = f
+	return ch, nil
+}
+
+func (ch *contentHashes) contains(hash uint64) bool {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	return ch.seen[hash]
+}
+
+func (ch *contentHashes) add(hash uint64) error {
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	if ch.seen[hash] {
+		return nil
+	}
+	ch.seen[hash] = true
+	if ch.deferred {
+		ch.pending = append(ch.pending, hash)
+		return nil
+	}
+	return binary.Write(ch.f, binary.LittleEndian, hash)
+}
+
+func (ch *contentHashes) persist() error {
+	// Append the deferred hashes added since the last call
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	err := writeHashes(ch.f, ch.pending)
+	if err != nil {
+		return err
+	}
+	ch.pending = nil
+	return nil
+}
+
+func (ch *contentHashes) close() error {
+	// Rewrite the file as sorted unique hashes, which is only
+	// possible when every earlier hash was loaded into the set
+	ch.mu.Lock()
+	defer ch.mu.Unlock()
+	err := ch.f.Close()
+	if err != nil || !ch.loaded {
+		return err
+	}
+	// Hashes never persisted belong to pages that weren't saved
+	for _, hash := range ch.pending {
+		delete(ch.seen, hash)
+	}
+	hashes := make([]uint64, 0, len(ch.seen))
+	for hash := range ch.seen {
+		hashes = append(hashes, hash)
+	}
+	slices.Sort(hashes)
+	path := ch.f.Name()
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".tmp-hashes-*")
+	if err != nil {
+		return err
+	}
+	err = writeHashes(tmp, hashes)
+	if err == nil {
+		err = tmp.Close()
+	} else {
+		tmp.Close()
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func writeHashes(w io.Writer, hashes []uint64) error {
+	b := make([]byte, 8*len(hashes))
+	for i, hash := range hashes {
+		binary.LittleEndian.PutUint64(b[8*i:], hash)
+	}
+	_, err := w.Write(b)
+	return err
+}
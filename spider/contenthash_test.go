@@ -0,0 +1,49 @@
+package spider
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDeferredContentHashes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), contentHashesFileName)
+	ch, err := openContentHashes(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch.deferred = true
+	fileSize := func() int64 {
+		t.Helper()
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return info.Size()
+	}
+	ch.add(1)
+	if size := fileSize(); size != 0 {
+		t.Errorf("deferred hash written before persist, file has %d bytes", size)
+	}
+	err = ch.persist()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := fileSize(); size != 8 {
+		t.Errorf("file has %d bytes after persist, want 8", size)
+	}
+	// Never persisted, so left out when the file is rewritten
+	ch.add(2)
+	err = ch.close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ch, err = openContentHashes(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ch.close()
+	if !ch.contains(1) || ch.contains(2) {
+		t.Errorf("reloaded hashes %v, want only 1", ch.seen)
+	}
+}
@@ -0,0 +1,59 @@
+package spider
+
+import (
+	"log/slog"
+	"sync"
+)
+
+// deadHosts counts the consecutive failed fetches of every
+// host, connection errors and 5xx responses other than the ones
+// throttling with a Retry-After. A host reaching the threshold is
+// given up on for the rest of the run and its queued URLs skipped
+
+type deadHosts struct {
+	mu          sync.Mutex
+	threshold   int
+	consecutive map[string]int
+	dead        map[string]bool
+}
+
+func newDeadHosts(threshold int) *deadHosts {
+	return &deadHosts{threshold: threshold, consecutive: make(map[string]int), dead: make(map[string]bool)}
+}
+
+func (dh *deadHosts) failed(hostname string) {
+	if dh.threshold <= 0 {
+		return
+	}
+	dh.mu.Lock()
+	defer dh.mu.Unlock()
+	dh.consecutive[hostname]++
+	if dh.consecutive[hostname] == dh.threshold {
+		dh.dead[hostname] = true
+		slog.Warn("spider - Host failed too many fetches in a row, skipping it for the rest of the run", "host", hostname, "failures", dh.threshold)
+	}
+}
+
+func (dh *deadHosts) succeeded(hostname string) {
+	if dh.threshold <= 0 {
+		return
+	}
+	dh.mu.Lock()
+	defer dh.mu.Unlock()
+	delete(dh.consecutive, hostname)
+}
+
+func (dh *deadHosts) isDead(hostname string) bool {
+	if dh.threshold <= 0 {
+		return false
+	}
+	dh.mu.Lock()
+	defer dh.mu.Unlock()
+	return dh.dead[hostname]
+}
+
+func (s *SearchHouseSpider) setMaxHostFailures(failures int) {
+	// Give up on a host after this many consecutive failed
+	// fetches, any other response resets the count. 0 never does
+	s.deadHosts = newDeadHosts(failures)
+}
@@ -0,0 +1,137 @@
+package spider
+
+import (
+	"encoding/json"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+const dedupReportFileName = "dedup_report.json"
+
+// dedupReport aggregates the duplicate detection decisions of
+// a crawl, written to dedup_report.json in the working directory
+// at shutdown to show how much deduplication saved
+
+type dedupReport struct {
+	mu          sync.Mutex
+	exact       int64
+	near        int64
+	canonical   int64
+	firstByHash map[uint64]string
+	clusters    map[string][]string
+	hosts       map[string]*hostDuplicates
+}
+
+// hostDuplicates counts the pages of a host that went through
+// duplicate detection and how many of them were duplicates
+
+type hostDuplicates struct {
+	Pages         int64   `json:"pages"`
+	Duplicates    int64   `json:"duplicates"`
+	DuplicateRate float64 `json:"duplicateRate"`
+}
+
+// duplicateCluster is a stored page and the URLs
+// skipped as duplicates of it
+
+type duplicateCluster struct {
+	Url        string   `json:"url"`
+	Duplicates []string `json:"duplicates"`
+}
+
+type dedupReportFile struct {
+	ExactDuplicates     int64                      `json:"exactDuplicates"`
+	NearDuplicates      int64                      `json:"nearDuplicates"`
+	CanonicalDuplicates int64                      `json:"canonicalDuplicates"`
+	Clusters            []duplicateCluster         `json:"clusters"`
+	Hosts               map[string]*hostDuplicates `json:"hosts"`
+}
+
+func newDedupReport() *dedupReport {
+	return &dedupReport{
+		firstByHash: make(map[uint64]string),
+		clusters:    make(map[string][]string),
+		hosts:       make(map[string]*hostDuplicates),
+	}
+}
+
+func (dr *dedupReport) host(rawUrl string) *hostDuplicates {
+	// Called with mu held
+	hostname := ""
+	if parsedUrl, err := url.Parse(rawUrl); err == nil {
+		hostname = parsedUrl.Hostname()
+	}
+	host, exists := dr.hosts[hostname]
+	if !exists {
+		host = &hostDuplicates{}
+		dr.hosts[hostname] = host
+	}
+	host.Pages++
+	return host
+}
+
+func (dr *dedupReport) recordStored(rawUrl string, contentHash uint64) {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	dr.host(rawUrl)
+	if _, exists := dr.firstByHash[contentHash]; !exists {
+		dr.firstByHash[contentHash] = rawUrl
+	}
+}
+
+func (dr *dedupReport) recordExact(rawUrl string, contentHash uint64) {
+	// The original is unknown when it was stored by an earlier
+	// run, the duplicate is then only counted
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	dr.exact++
+	dr.host(rawUrl).Duplicates++
+	if original, exists := dr.firstByHash[contentHash]; exists {
+		dr.clusters[original] = append(dr.clusters[original], rawUrl)
+	}
+}
+
+func (dr *dedupReport) recordDuplicate(rawUrl string, original string, canonical bool) {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	if canonical {
+		dr.canonical++
+	} else {
+		dr.near++
+	}
+	dr.host(rawUrl).Duplicates++
+	dr.clusters[original] = append(dr.clusters[original], rawUrl)
+}
+
+func (dr *dedupReport) write(path string) error {
+	dr.mu.Lock()
+	defer dr.mu.Unlock()
+	report := dedupReportFile{
+		ExactDuplicates:     dr.exact,
+		NearDuplicates:      dr.near,
+		CanonicalDuplicates: dr.canonical,
+		Clusters:            make([]duplicateCluster, 0, len(dr.clusters)),
+		Hosts:               dr.hosts,
+	}
+	for original, duplicates := range dr.clusters {
+		report.Clusters = append(report.Clusters, duplicateCluster{Url: original, Duplicates: duplicates})
+	}
+	sort.Slice(report.Clusters, func(i, j int) bool {
+		return report.Clusters[i].Url < report.Clusters[j].Url
+	})
+	for _, host := range dr.hosts {
+		host.DuplicateRate = float64(host.Duplicates) / float64(host.Pages)
+	}
+	b, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0666)
+}
+
+func (s *SearchHouseSpider) writeDedupReport() error {
+	return s.dedup.write(filepath.Join(s.workingDirectory, dedupReportFileName))
+}
@@ -0,0 +1,57 @@
+package spider
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// discoveredSink streams every URL enqueued during the crawl, one
+// per line, to a file or stdout. Each URL is only written once
+
+type discoveredSink struct {
+	mu      sync.Mutex
+	w       io.WriteCloser
+	emitted map[string]bool
+}
+
+func (s *SearchHouseSpider) setDiscoveredSink(path string) error {
+	// Write every URL enqueued to path as it's discovered,
+	// - writes them to stdout
+	var w io.WriteCloser = os.Stdout
+	if path != "-" {
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		if err != nil {
+			return err
+		}
+		w = f
+	}
+	s.discovered = &discoveredSink{w: w, emitted: make(map[string]bool)}
+	return nil
+}
+
+func (ds *discoveredSink) emit(url string) {
+	if ds == nil {
+		return
+	}
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if ds.emitted[url] {
+		return
+	}
+	ds.emitted[url] = true
+	_, err := io.WriteString(ds.w, url+"\n")
+	if err != nil {
+		slog.Error("spider - Error writing discovered URL", "err", err)
+	}
+}
+
+func (ds *discoveredSink) close() {
+	if ds == nil || ds.w == os.Stdout {
+		return
+	}
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	ds.w.Close()
+}
@@ -0,0 +1,49 @@
+package spider
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+)
+
+// diskBudget ends the crawl once the pages written this run add
+// up to more than maxBytes, rather than crashing when the disk
+// fills up. Sizes are those of the files written, so compressed
+// pages count for their compressed size
+
+type diskBudget struct {
+	maxBytes int64
+	used     atomic.Int64
+	stop     context.CancelFunc
+	once     sync.Once
+}
+
+func (s *SearchHouseSpider) setMaxDiskBytes(maxBytes int64) {
+	// Stop crawling once maxBytes of pages were written, 0 never stops
+	if maxBytes <= 0 {
+		s.diskBudget = nil
+		return
+	}
+	s.diskBudget = &diskBudget{maxBytes: maxBytes}
+}
+
+func (db *diskBudget) add(size int64) {
+	if db == nil {
+		return
+	}
+	used := db.used.Add(size)
+	if used <= db.maxBytes {
+		return
+	}
+	db.once.Do(func() {
+		slog.Warn("spider - Disk budget exceeded, stopping the crawl", "written", used, "maxDiskBytes", db.maxBytes)
+		if db.stop != nil {
+			db.stop()
+		}
+	})
+}
+
+func (db *diskBudget) exceeded() bool {
+	return db != nil && db.used.Load() > db.maxBytes
+}
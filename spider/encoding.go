@@ -0,0 +1,61 @@
+package spider
+
+import (
+	"compress/gzip"
+	"fmt"
+	"github.com/andybalholm/brotli"
+	"io"
+	"strings"
+)
+
+// Accept-Encoding sent with crawl requests by default
+const DefaultAcceptEncoding = "gzip, br"
+
+// Content-Encodings decodeBody decompresses
+var supportedEncodings = map[string]bool{"identity": true, "gzip": true, "x-gzip": true, "br": true}
+
+// countingReader counts the bytes read through it, which for
+// a response body are the bytes received before decompression
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
+
+func (s *SearchHouseSpider) setAcceptEncoding(encodings string) error {
+	// The Accept-Encoding of crawl requests, which may only offer
+	// the encodings decodeBody decompresses, with or without a
+	// q-value. "" leaves it to Go's transport, which asks for gzip
+	// and decompresses it without reporting the compressed size
+	s.acceptEncoding = strings.TrimSpace(encodings)
+	if s.acceptEncoding == "" {
+		return nil
+	}
+	for _, encoding := range strings.Split(s.acceptEncoding, ",") {
+		name, _, _ := strings.Cut(encoding, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !supportedEncodings[name] {
+			return fmt.Errorf("unsupported encoding %q in Accept-Encoding %q", name, encodings)
+		}
+	}
+	return nil
+}
+
+func decodeBody(contentEncoding string, body io.Reader) (io.Reader, error) {
+	switch strings.ToLower(strings.TrimSpace(contentEncoding)) {
+	case "", "identity":
+		return body, nil
+	case "gzip", "x-gzip":
+		return gzip.NewReader(body)
+	case "br":
+		return brotli.NewReader(body), nil
+	default:
+		return nil, fmt.Errorf("unsupported Content-Encoding %q", contentEncoding)
+	}
+}
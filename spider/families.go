@@ -1,7 +1,7 @@
 package spider
 
 import (
-	"fmt"
+	"log/slog"
 	"strings"
 )
 
@@ -35,7 +35,7 @@ func (uf *UselessFamilies) Insert(url string) {
 func (uf *UselessFamilies) Useless(url string) bool {
 	for family := range uf.db {
 		if strings.Contains(url, family) {
-			fmt.Printf("USELESS FAMILY:\tPage %s determined to come from a useless family. Excluding from frontier.\n", url)
+			slog.Info("families - Page comes from a useless family, excluding it from the frontier", "url", url)
 			return true
 		}
 	}
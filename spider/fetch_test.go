@@ -0,0 +1,307 @@
+package spider
+
+import (
+	"errors"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"searchHouse/common"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func chunkedServer(t *testing.T, chunks int, chunk string) *httptest.Server {
+	// Streams chunks without a Content-Length, flushing
+	// each so the response is sent chunked
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for i := 0; i < chunks; i++ {
+			_, err := w.Write([]byte(chunk))
+			if err != nil {
+				return
+			}
+			w.(http.Flusher).Flush()
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestReadBodyChunkedOverLimit(t *testing.T) {
+	s := newTestSpider(t, func(config *Config) { config.MaxBodyBytes = 4096 })
+	chunk := strings.Repeat("x", 1024)
+	srv := chunkedServer(t, 1000, chunk)
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.ContentLength != -1 {
+		t.Fatalf("response has a Content-Length of %d, want none", resp.ContentLength)
+	}
+	_, err = s.readBody(resp)
+	if !errors.Is(err, errBodyTooLarge) {
+		t.Fatalf("got error %v, want errBodyTooLarge", err)
+	}
+	if !strings.Contains(err.Error(), "without Content-Length") {
+		t.Errorf("error %q doesn't say the body was streamed", err)
+	}
+	// Reading stops past the limit rather than draining the stream
+	if received := s.stats.Snapshot().Bytes; received > 64*1024 {
+		t.Errorf("read %d bytes of a body limited to 4096", received)
+	}
+}
+
+func TestReadBodyChunkedUnderLimit(t *testing.T) {
+	s := newTestSpider(t, func(config *Config) { config.MaxBodyBytes = 4096 })
+	srv := chunkedServer(t, 4, strings.Repeat("x", 1024))
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := s.readBody(resp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body) != 4096 {
+		t.Errorf("read %d bytes, want 4096", len(body))
+	}
+}
+
+func TestCheckRedirectRecordsAbsoluteLocations(t *testing.T) {
+	s := newTestSpider(t, func(config *Config) {
+		config.AllowPrivate = true
+		config.RecordRedirects = true
+	})
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/start":
+			// Root-relative
+			w.Header().Set("Location", "/dir/page")
+			w.WriteHeader(http.StatusMovedPermanently)
+		case "/dir/page":
+			// Relative to the current directory
+			w.Header().Set("Location", "next")
+			w.WriteHeader(http.StatusFound)
+		case "/dir/next":
+			w.Header().Set("Location", srv.URL+"/end")
+			w.WriteHeader(http.StatusTemporaryRedirect)
+		default:
+			w.Write([]byte("done"))
+		}
+	}))
+	defer srv.Close()
+	resp, redirects, _, err := s.fetch(srv.URL+"/start", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	want := []common.RedirectHop{
+		{Status: http.StatusMovedPermanently, Url: srv.URL + "/start", Location: srv.URL + "/dir/page"},
+		{Status: http.StatusFound, Url: srv.URL + "/dir/page", Location: srv.URL + "/dir/next"},
+		{Status: http.StatusTemporaryRedirect, Url: srv.URL + "/dir/next", Location: srv.URL + "/end"},
+	}
+	if !slices.Equal(redirects, want) {
+		t.Errorf("got redirects %+v, want %+v", redirects, want)
+	}
+}
+
+func TestCheckRedirectStopsAfterMaxRedirects(t *testing.T) {
+	s := newTestSpider(t, func(config *Config) {
+		config.AllowPrivate = true
+		config.MaxRedirects = 2
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, r.URL.Path+"x", http.StatusFound)
+	}))
+	defer srv.Close()
+	_, _, _, err := s.fetch(srv.URL+"/loop", "")
+	if err == nil || !strings.Contains(err.Error(), "stopped after 2 redirects") {
+		t.Errorf("got error %v, want the redirect limit", err)
+	}
+}
+
+func BenchmarkFetchConnectionReuse(b *testing.B) {
+	// Sequential fetches of one TLS host, with idle connections
+	// kept for reuse and with a handshake for every request
+	for _, keepAlive := range []bool{true, false} {
+		b.Run("keepAlive="+strconv.FormatBool(keepAlive), func(b *testing.B) {
+			var handshakes atomic.Int64
+			srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Write([]byte("<p>page</p>"))
+			}))
+			srv.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+				if state == http.StateNew {
+					handshakes.Add(1)
+				}
+			}
+			srv.StartTLS()
+			defer srv.Close()
+			s := newTestSpider(b, func(config *Config) {
+				config.AllowPrivate = true
+			})
+			s.transport.TLSClientConfig = srv.Client().Transport.(*http.Transport).TLSClientConfig
+			s.transport.DisableKeepAlives = !keepAlive
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				resp, _, _, err := s.fetch(srv.URL+"/p", "")
+				if err != nil {
+					b.Fatal(err)
+				}
+				_, err = s.readBody(resp)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(handshakes.Load())/float64(b.N), "handshakes/op")
+		})
+	}
+}
+
+func TestRetryAfterRequeuesAreCapped(t *testing.T) {
+	s := newTestSpider(t, func(config *Config) {
+		config.AllowPrivate = true
+		config.MaxRetries = 2
+		config.MaxHostFailures = 0
+	})
+	var requests atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Retry-After", "0")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer srv.Close()
+	entry := FrontierEntry{Url: srv.URL + "/p"}
+	for {
+		s.crawlPage(0, entry, nil)
+		entry = s.frontier.PopEntry(0)
+		if entry.Url == "" {
+			break
+		}
+	}
+	// The first fetch and two retries
+	if got := requests.Load(); got != 3 {
+		t.Errorf("got %d requests, want 3", got)
+	}
+}
+
+func TestAcceptEncodingValidated(t *testing.T) {
+	s := newTestSpider(t, nil)
+	for _, encoding := range []string{"", "gzip", "gzip, br", "br;q=1.0, gzip;q=0.5, identity;q=0.1", "GZIP"} {
+		if err := s.setAcceptEncoding(encoding); err != nil {
+			t.Errorf("Accept-Encoding %q rejected: %v", encoding, err)
+		}
+	}
+	for _, encoding := range []string{"gzip, zstd", "deflate", "*", "gzip,,br"} {
+		if err := s.setAcceptEncoding(encoding); err == nil {
+			t.Errorf("Accept-Encoding %q accepted", encoding)
+		}
+	}
+}
+
+func TestThrottlingHostNotDead(t *testing.T) {
+	s := newTestSpider(t, func(config *Config) {
+		config.AllowPrivate = true
+		config.MaxHostFailures = 2
+		config.MaxRetries = 5
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/throttled":
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case "/unavailable":
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	hostname := s.getHostname(srv.URL)
+	for _, path := range []string{"/throttled", "/unavailable", "/throttled", "/missing", "/gone", "/missing"} {
+		s.crawlPage(0, FrontierEntry{Url: srv.URL + path}, nil)
+	}
+	if s.deadHosts.isDead(hostname) {
+		t.Error("host answering 429, 503 with Retry-After and 404 was marked dead")
+	}
+}
+
+func TestServerErrorsMarkHostDead(t *testing.T) {
+	s := newTestSpider(t, func(config *Config) {
+		config.AllowPrivate = true
+		config.MaxHostFailures = 2
+	})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	for _, path := range []string{"/a", "/b"} {
+		s.crawlPage(0, FrontierEntry{Url: srv.URL + path}, nil)
+	}
+	if !s.deadHosts.isDead(s.getHostname(srv.URL)) {
+		t.Error("host answering 500s wasn't marked dead")
+	}
+}
+
+func TestProbesSendUserAgent(t *testing.T) {
+	for _, hostUserAgents := range []map[string]string{nil, {"127.0.0.1": "searchHouse-host"}} {
+		s := newTestSpider(t, func(config *Config) {
+			config.AllowPrivate = true
+			config.UserAgent = "searchHouse-default"
+			config.HostUserAgents = hostUserAgents
+		})
+		want := s.userAgentFor("127.0.0.1")
+		userAgents := make(chan string, 2)
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userAgents <- r.UserAgent()
+		}))
+		s.transport.TLSClientConfig = srv.Client().Transport.(*http.Transport).TLSClientConfig
+		resp, err := s.probeHost(s.client, s.getHostname(srv.URL))
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		s.probeWordPress(srv.URL + "/wp-admin/")
+		srv.Close()
+		close(userAgents)
+		for userAgent := range userAgents {
+			if userAgent != want {
+				t.Errorf("probe sent User-Agent %q, want %q", userAgent, want)
+			}
+		}
+	}
+}
+
+func TestH2C(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		s := newTestSpider(t, func(config *Config) {
+			config.AllowPrivate = true
+			config.H2C = enabled
+		})
+		srv := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Write([]byte(r.Proto))
+		}), &http2.Server{}))
+		resp, _, _, err := s.fetch(srv.URL+"/p", "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := s.readBody(resp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		srv.Close()
+		want := "HTTP/1.1"
+		if enabled {
+			want = "HTTP/2.0"
+		}
+		if string(body) != want || resp.Proto != want {
+			t.Errorf("h2c=%v: server saw %s and client got %s, want %s", enabled, body, resp.Proto, want)
+		}
+	}
+}
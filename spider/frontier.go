@@ -2,18 +2,38 @@ package spider
 
 import (
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	_ "github.com/mattn/go-sqlite3"
+	"io"
 	"log"
+	"log/slog"
 	"os"
+	"strings"
 	"sync"
 )
 
+// FrontierEntry is a URL pending in the frontier along with
+// its depth from the seeds, the priority it's popped by, the
+// URL of the page it was found on, whether it was found
+// outside the seed sites by following a link one hop out and
+// how many times it was requeued after a Retry-After
+
+type FrontierEntry struct {
+	Url      string  `json:"url"`
+	Depth    int     `json:"depth"`
+	Priority float64 `json:"priority"`
+	Referer  string  `json:"referer,omitempty"`
+	External bool    `json:"external,omitempty"`
+	Retries  int     `json:"retries,omitempty"`
+}
+
 type Frontier struct {
 	db          *sql.DB
 	initialized bool
 	mutex       sync.Mutex
+	fifo        bool
 }
 
 func (f *Frontier) Init() {
@@ -23,7 +43,7 @@ func (f *Frontier) Init() {
 		log.Fatal(err)
 	}
 	if !exists {
-		log.Println("frontier - Creating SQLite database for frontier...")
+		slog.Info("frontier - Creating SQLite database for frontier")
 		file, err := os.Create("frontier.db")
 		if err != nil {
 			log.Fatal(err)
@@ -47,53 +67,165 @@ func (f *Frontier) createTable() {
 	if !f.initialized {
 		log.Fatal("Must initialize database connection before operating on it")
 	}
-	log.Println("frontier - Creating table frontier and indexes...")
+	slog.Info("frontier - Creating table frontier and indexes")
 	createDB := `CREATE TABLE IF NOT EXISTS frontier (
 					url TEXT PRIMARY KEY,
 					goroutine INT NOT NULL
-				 );
-				 CREATE INDEX idx_goroutines
-				 ON frontier (goroutine);`
-	statement, err := f.db.Prepare(createDB)
+				 );`
+	_, err := f.db.Exec(createDB)
 	if err != nil {
 		log.Fatal(err)
 	}
-	_, err = statement.Exec()
+	f.migrateTable()
+	// Created separately, a statement only runs its first
+	// command, and after the migration adds the priority.
+	// It covers PopEntry's lookup of a routine's next URL
+	_, err = f.db.Exec(`CREATE INDEX IF NOT EXISTS idx_goroutines ON frontier (goroutine, priority DESC);`)
 	if err != nil {
 		log.Fatal(err)
 	}
 }
 
-func (f *Frontier) PopURL(routineNum int) string {
-	// Query and return a URL from the frontier DB
-	// true if frontier isn't empty, false otherwise
+func (f *Frontier) migrateTable() {
+	// Frontiers created before URLs had a depth, priority, referer,
+	// origin and retry count are missing those columns, add them
+	// with defaults
+	columns := []string{
+		"ALTER TABLE frontier ADD COLUMN depth INT NOT NULL DEFAULT 0;",
+		"ALTER TABLE frontier ADD COLUMN priority REAL NOT NULL DEFAULT 0;",
+		"ALTER TABLE frontier ADD COLUMN referer TEXT NOT NULL DEFAULT '';",
+		"ALTER TABLE frontier ADD COLUMN external INT NOT NULL DEFAULT 0;",
+		"ALTER TABLE frontier ADD COLUMN retries INT NOT NULL DEFAULT 0;",
+	}
+	for _, column := range columns {
+		_, err := f.db.Exec(column)
+		if err != nil && !strings.Contains(err.Error(), "duplicate column name") {
+			log.Fatal(err)
+		}
+	}
+}
+
+func (f *Frontier) PopEntry(routineNum int) FrontierEntry {
+	// Query and return the highest priority entry of the
+	// routine (or the oldest one in FIFO mode) from the
+	// frontier DB, the returned entry has an empty URL if
+	// the routine's frontier is empty
 	if !f.initialized {
 		log.Fatal("Must initialize database connection before operating on it")
 	}
-	var url string
-	query := fmt.Sprintf("SELECT url FROM frontier WHERE goroutine = %d LIMIT 1", routineNum)
+	var entry FrontierEntry
+	order := "priority DESC, rowid ASC"
+	if f.fifo {
+		order = "rowid ASC"
+	}
+	query := fmt.Sprintf("SELECT url, depth, priority, referer, external, retries FROM frontier WHERE goroutine = %d ORDER BY %s LIMIT 1", routineNum, order)
 
 	f.mutex.Lock()
 	defer f.mutex.Unlock()
 
 	result := f.db.QueryRow(query)
-	err := result.Scan(&url)
+	err := result.Scan(&entry.Url, &entry.Depth, &entry.Priority, &entry.Referer, &entry.External, &entry.Retries)
 	if err != nil {
-		return ""
+		return FrontierEntry{}
 	}
 
-	query = fmt.Sprintf(`DELETE FROM frontier WHERE url = '%s';`, url)
-	statement, err := f.db.Prepare(query)
+	// URLs may contain quotes, so they're always bound as parameters
+	_, err = f.db.Exec(`DELETE FROM frontier WHERE url = ?;`, entry.Url)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	_, err = statement.Exec()
+	return entry
+}
+
+func (f *Frontier) Len() int {
+	// Number of entries pending across every routine
+	if !f.initialized {
+		log.Fatal("Must initialize database connection before operating on it")
+	}
+	var count int
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	err := f.db.QueryRow("SELECT COUNT(*) FROM frontier;").Scan(&count)
 	if err != nil {
 		log.Fatal(err)
 	}
+	return count
+}
 
-	return url
+func (f *Frontier) PartitionSizes() map[int]int {
+	// Number of entries pending for each routine
+	// with any, keyed by the routine's number
+	if !f.initialized {
+		log.Fatal("Must initialize database connection before operating on it")
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	rows, err := f.db.Query("SELECT goroutine, COUNT(*) FROM frontier GROUP BY goroutine;")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer rows.Close()
+	sizes := make(map[int]int)
+	for rows.Next() {
+		var routineNum, count int
+		err = rows.Scan(&routineNum, &count)
+		if err != nil {
+			log.Fatal(err)
+		}
+		sizes[routineNum] = count
+	}
+	return sizes
+}
+
+func (f *Frontier) Repartition(partition func(url string) int) (int, error) {
+	// Move every entry whose routine isn't partition(url), e.g.
+	// left by a crawl run with a different number of routines,
+	// whose entries would otherwise never be popped or split a
+	// host across routines. Returns the number of entries moved
+	if !f.initialized {
+		log.Fatal("Must initialize database connection before operating on it")
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	rows, err := f.db.Query("SELECT url, goroutine FROM frontier;")
+	if err != nil {
+		return 0, err
+	}
+	moves := make(map[string]int)
+	for rows.Next() {
+		var url string
+		var routineNum int
+		err = rows.Scan(&url, &routineNum)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if target := partition(url); target != routineNum {
+			moves[url] = target
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return 0, err
+	}
+	tx, err := f.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	for url, routineNum := range moves {
+		_, err = tx.Exec("UPDATE frontier SET goroutine = ? WHERE url = ?;", routineNum, url)
+		if err != nil {
+			tx.Rollback()
+			return 0, err
+		}
+	}
+	return len(moves), tx.Commit()
+}
+
+func (f *Frontier) SetFIFO(enabled bool) {
+	// Pop entries in insertion order, ignoring their priority
+	f.fifo = enabled
 }
 
 func (f *Frontier) CheckURLInFrontier(url string) bool {
@@ -105,10 +237,9 @@ func (f *Frontier) CheckURLInFrontier(url string) bool {
 		log.Fatal("Must initialize database connection before operating on it")
 	}
 	var exists bool
-	query := fmt.Sprintf(`SELECT EXISTS(SELECT 1 FROM frontier WHERE url = '%s');`, url)
 	f.mutex.Lock()
 	defer f.mutex.Unlock()
-	result := f.db.QueryRow(query)
+	result := f.db.QueryRow(`SELECT EXISTS(SELECT 1 FROM frontier WHERE url = ?);`, url)
 	err := result.Scan(&exists)
 	if err != nil {
 		log.Fatal(err)
@@ -117,22 +248,65 @@ func (f *Frontier) CheckURLInFrontier(url string) bool {
 }
 
 func (f *Frontier) InsertPage(url string, routineNum int) {
+	f.InsertEntry(FrontierEntry{Url: url}, routineNum)
+}
+
+func (f *Frontier) InsertEntry(entry FrontierEntry, routineNum int) {
 	if !f.initialized {
 		log.Fatal("Must initialize database connection before operating on it")
 	}
-	query := fmt.Sprintf(`INSERT OR IGNORE INTO frontier (url, goroutine) VALUES ('%s', %d);`, url, routineNum)
 	f.mutex.Lock()
 	defer f.mutex.Unlock()
-	statement, err := f.db.Prepare(query)
+	// Change to non-fatal log to prevent crashing
+	_, err := f.db.Exec(`INSERT OR IGNORE INTO frontier (url, goroutine, depth, priority, referer, external, retries) VALUES (?, ?, ?, ?, ?, ?, ?);`,
+		entry.Url, routineNum, entry.Depth, entry.Priority, entry.Referer, entry.External, entry.Retries)
 	if err != nil {
-		log.Fatal(err)
+		slog.Error("frontier - Error inserting entry", "err", err)
 	}
-	// Change to non-fatal log to prevent crashing
-	_, err = statement.Exec()
+}
+
+func (f *Frontier) Export(w io.Writer) error {
+	// Write every pending entry as a line of JSON, leaving
+	// out the routine since it's specific to this crawl
+	if !f.initialized {
+		log.Fatal("Must initialize database connection before operating on it")
+	}
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	rows, err := f.db.Query("SELECT url, depth, priority, referer, external, retries FROM frontier;")
 	if err != nil {
-		log.Println(err)
+		return err
+	}
+	defer rows.Close()
+	encoder := json.NewEncoder(w)
+	for rows.Next() {
+		var entry FrontierEntry
+		err = rows.Scan(&entry.Url, &entry.Depth, &entry.Priority, &entry.Referer, &entry.External, &entry.Retries)
+		if err != nil {
+			return err
+		}
+		err = encoder.Encode(entry)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (f *Frontier) Import(r io.Reader, partition func(url string) int) error {
+	// Read URLs written by Export and insert them, using
+	// partition to assign each one to a routine of this crawl
+	decoder := json.NewDecoder(r)
+	for {
+		var entry FrontierEntry
+		err := decoder.Decode(&entry)
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		f.InsertEntry(entry, partition(entry.Url))
 	}
-	return
 }
 
 func (f *Frontier) fileExists(path string) (bool, error) {
@@ -146,3 +320,66 @@ func (f *Frontier) fileExists(path string) (bool, error) {
 		return false, err
 	}
 }
+
+func DumpFrontier(dbName string, w io.Writer, sampleSize int) error {
+	// Print how many URLs each routine has queued along with a
+	// sample of the next ones it would pop. The database is
+	// opened read-only so this is safe to run next to a crawl
+	if _, err := os.Stat(dbName); err != nil {
+		return err
+	}
+	db, err := sql.Open("sqlite3", "file:"+dbName+"?mode=ro")
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	rows, err := db.Query("SELECT goroutine, COUNT(*) FROM frontier GROUP BY goroutine ORDER BY goroutine;")
+	if err != nil {
+		return err
+	}
+	counts := make(map[int]int)
+	routines := make([]int, 0)
+	for rows.Next() {
+		var routine, count int
+		err = rows.Scan(&routine, &count)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		counts[routine] = count
+		routines = append(routines, routine)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	total := 0
+	for _, routine := range routines {
+		total += counts[routine]
+		_, err = fmt.Fprintf(w, "routine %d: %d queued\n", routine, counts[routine])
+		if err != nil {
+			return err
+		}
+		sample, err := db.Query("SELECT url FROM frontier WHERE goroutine = ? ORDER BY priority DESC, rowid ASC LIMIT ?;", routine, sampleSize)
+		if err != nil {
+			return err
+		}
+		for sample.Next() {
+			var url string
+			err = sample.Scan(&url)
+			if err == nil {
+				_, err = fmt.Fprintf(w, "  %s\n", url)
+			}
+			if err != nil {
+				sample.Close()
+				return err
+			}
+		}
+		sample.Close()
+		if err = sample.Err(); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "%d URLs queued across %d routines\n", total, len(routines))
+	return err
+}
@@ -0,0 +1,85 @@
+package spider
+
+import (
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestFrontierQuotes(t *testing.T) {
+	s := newTestSpider(t, nil)
+	entry := FrontierEntry{Url: "https://a.com/it's", Depth: 2, Priority: 1.5, Referer: "https://a.com/o'neil", External: true}
+	s.frontier.InsertEntry(entry, 0)
+	if !s.frontier.CheckURLInFrontier(entry.Url) {
+		t.Fatal("entry with a quote isn't in the frontier")
+	}
+	if got := s.frontier.PopEntry(0); got != entry {
+		t.Errorf("popped %+v, want %+v", got, entry)
+	}
+	if s.frontier.CheckURLInFrontier(entry.Url) {
+		t.Error("popped entry is still in the frontier")
+	}
+}
+
+func TestFrontierPopUsesIndex(t *testing.T) {
+	s := newTestSpider(t, nil)
+	var plan string
+	rows, err := s.frontier.db.Query("EXPLAIN QUERY PLAN SELECT url FROM frontier WHERE goroutine = 0 ORDER BY priority DESC, rowid ASC LIMIT 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id, parent, unused int
+		var detail string
+		err = rows.Scan(&id, &parent, &unused, &detail)
+		if err != nil {
+			t.Fatal(err)
+		}
+		plan += detail + "\n"
+	}
+	if !strings.Contains(plan, "idx_goroutines") {
+		t.Errorf("popping doesn't use idx_goroutines:\n%s", plan)
+	}
+}
+
+func TestNewSpiderLeavesFrontierAlone(t *testing.T) {
+	s := newTestSpider(t, func(config *Config) { config.NumRoutines = 4 })
+	s.frontier.InsertEntry(FrontierEntry{Url: "https://a.com/"}, 3)
+	// E.g. -exportFrontier, run with the default single routine
+	config := s.config
+	config.NumRoutines = 1
+	_, err := NewSpider(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sizes := s.frontier.PartitionSizes(); sizes[3] != 1 {
+		t.Errorf("got partitions %v, want the URL left in routine 3", sizes)
+	}
+}
+
+func TestRepartitionFrontier(t *testing.T) {
+	for _, numRoutines := range []int{1, 4, 7} {
+		s := newTestSpider(t, func(config *Config) { config.NumRoutines = numRoutines })
+		urls := []string{"https://a.com/", "https://b.com/", "https://c.com/", "https://d.com/", "https://e.com/"}
+		// As left by a crawl with 5 routines
+		for i, url := range urls {
+			s.frontier.InsertEntry(FrontierEntry{Url: url}, i)
+		}
+		_, err := s.frontier.Repartition(s.calcWebsiteToRoutineNum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := make(map[int]int)
+		for _, url := range urls {
+			want[s.calcWebsiteToRoutineNum(url)]++
+		}
+		if got := s.frontier.PartitionSizes(); !maps.Equal(got, want) {
+			t.Errorf("with %d routines got partitions %v, want %v", numRoutines, got, want)
+		}
+		moved, err := s.frontier.Repartition(s.calcWebsiteToRoutineNum)
+		if err != nil || moved != 0 {
+			t.Errorf("repartitioning again moved %d entries, err %v", moved, err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package spider
+
+import (
+	"sync"
+	"time"
+)
+
+// HostConfig overrides the global crawl settings for a single
+// host, zero values (and a nil CrawlDelay) leave them unchanged
+
+type HostConfig struct {
+	MaxPages   int
+	MaxDepth   int
+	CrawlDelay *time.Duration
+	// Only crawl the host's URLs under one of these paths
+	PathPrefixes []string
+}
+
+// hostConfigs looks up the HostConfig of a host and counts
+// the pages stored for it so MaxPages can be enforced. It also
+// holds the delays hosts asked for with Retry-After until the
+// routine crawling them waits
+
+type hostConfigs struct {
+	mu         sync.Mutex
+	configs    map[string]HostConfig
+	stored     map[string]int
+	retryAfter map[string]time.Duration
+}
+
+func newHostConfigs(configs map[string]HostConfig) *hostConfigs {
+	return &hostConfigs{configs: configs, stored: make(map[string]int), retryAfter: make(map[string]time.Duration)}
+}
+
+func (hc *hostConfigs) delay(hostname string, fallback time.Duration) time.Duration {
+	// The delay before the next request of the routine crawling
+	// hostname, a pending Retry-After is only waited once
+	delay := fallback
+	if config, exists := hc.configs[hostname]; exists && config.CrawlDelay != nil {
+		delay = *config.CrawlDelay
+	}
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	if retryAfter, exists := hc.retryAfter[hostname]; exists {
+		delete(hc.retryAfter, hostname)
+		delay = max(delay, retryAfter)
+	}
+	return delay
+}
+
+func (hc *hostConfigs) setRetryAfter(hostname string, delay time.Duration) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.retryAfter[hostname] = delay
+}
+
+func (hc *hostConfigs) pendingRetryAfter(hostname string) time.Duration {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	return hc.retryAfter[hostname]
+}
+
+func (hc *hostConfigs) depthAllowed(hostname string, depth int) bool {
+	config, exists := hc.configs[hostname]
+	return !exists || config.MaxDepth <= 0 || depth <= config.MaxDepth
+}
+
+func (hc *hostConfigs) pathPrefixes(hostname string, fallback []string) []string {
+	if config, exists := hc.configs[hostname]; exists && len(config.PathPrefixes) > 0 {
+		return config.PathPrefixes
+	}
+	return fallback
+}
+
+func (hc *hostConfigs) capReached(hostname string) bool {
+	config, exists := hc.configs[hostname]
+	if !exists || config.MaxPages <= 0 {
+		return false
+	}
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	return hc.stored[hostname] >= config.MaxPages
+}
+
+func (hc *hostConfigs) recordStored(hostname string) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	hc.stored[hostname]++
+}
+
+func (hc *hostConfigs) storedSnapshot() map[string]int {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	stored := make(map[string]int, len(hc.stored))
+	for hostname, count := range hc.stored {
+		stored[hostname] = count
+	}
+	return stored
+}
+
+func (hc *hostConfigs) restoreStored(stored map[string]int) {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	for hostname, count := range stored {
+		hc.stored[hostname] += count
+	}
+}
@@ -0,0 +1,68 @@
+package spider
+
+import (
+	"log/slog"
+	"net/url"
+	"searchHouse/common"
+	"strings"
+	"sync"
+)
+
+// hostForms remembers whether a site prefers its www. or apex
+// host, learned from the first redirect between the two, so
+// both spellings of its URLs are stored as a single page
+
+type hostForms struct {
+	mu        sync.Mutex
+	preferred map[string]string
+}
+
+func newHostForms() *hostForms {
+	return &hostForms{preferred: make(map[string]string)}
+}
+
+func (hf *hostForms) observeRedirect(from *url.URL, to *url.URL) {
+	// Only a redirect between the www. and apex forms of
+	// the same host decides the policy, and only the first
+	fromHost, toHost := strings.ToLower(from.Host), strings.ToLower(to.Host)
+	if fromHost == toHost || apexHost(fromHost) != apexHost(toHost) {
+		return
+	}
+	hf.mu.Lock()
+	defer hf.mu.Unlock()
+	if _, exists := hf.preferred[apexHost(fromHost)]; exists {
+		return
+	}
+	hf.preferred[apexHost(fromHost)] = toHost
+	slog.Info("spider - Host permanently redirects, rewriting its URLs", "from", fromHost, "to", toHost)
+}
+
+func (hf *hostForms) rewrite(rawUrl string) string {
+	// Rewrite a canonical URL to the preferred form of its host
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil || parsedUrl.Host == "" {
+		return rawUrl
+	}
+	hf.mu.Lock()
+	preferred, exists := hf.preferred[apexHost(parsedUrl.Host)]
+	hf.mu.Unlock()
+	if !exists || preferred == parsedUrl.Host {
+		return rawUrl
+	}
+	parsedUrl.Host = preferred
+	return parsedUrl.String()
+}
+
+func apexHost(host string) string {
+	return strings.TrimPrefix(host, "www.")
+}
+
+func (s *SearchHouseSpider) canonicalize(rawUrl string) string {
+	// Canonicalize a URL and, once a site's preferred host is
+	// known, rewrite it to that host before it's hashed or queued
+	canonical := common.CanonicalizeWithOptions(rawUrl, common.CanonicalizeOptions{LowercasePath: s.lowercasePaths})
+	if s.hostForms == nil {
+		return canonical
+	}
+	return s.hostForms.rewrite(canonical)
+}
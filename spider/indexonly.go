@@ -0,0 +1,73 @@
+package spider
+
+import (
+	"log/slog"
+	"searchHouse/common"
+	"sync"
+)
+
+// PageSink replaces writing pages to disk in index-only mode,
+// typically adding them to a search index. Like OnPageStored
+// it's called concurrently from every crawl routine
+
+type PageSink func(page *common.WebPage) error
+
+// indexedPages tracks the pages handed to the PageSink, which
+// stand in for the stored pages when checking for downloads
+
+type indexedPages struct {
+	sink  PageSink
+	flush func() error
+	mu    sync.Mutex
+	urls  StringSet
+}
+
+func (s *SearchHouseSpider) setIndexOnly(sink PageSink, flush func() error, indexed []string) {
+	// Hand pages to sink instead of writing them to the page
+	// directory and save them with flush, if not nil. indexed are
+	// the URLs already in the index, e.g. from a previous run,
+	// which are treated as downloaded
+	if sink == nil {
+		s.indexOnly = nil
+		return
+	}
+	s.indexOnly = &indexedPages{sink: sink, flush: flush}
+	for _, url := range indexed {
+		s.indexOnly.urls.Add(s.canonicalize(url))
+	}
+}
+
+func (ip *indexedPages) add(page *common.WebPage, key string) error {
+	err := ip.sink(page)
+	if err != nil {
+		return err
+	}
+	ip.mu.Lock()
+	defer ip.mu.Unlock()
+	ip.urls.Add(key)
+	return nil
+}
+
+func (ip *indexedPages) contains(key string) bool {
+	ip.mu.Lock()
+	defer ip.mu.Unlock()
+	return ip.urls.Contains(key)
+}
+
+func (s *SearchHouseSpider) flushIndex() {
+	// Save the pages handed to the sink, and only then their
+	// content hashes, so pages lost to a crash are crawled again
+	// rather than skipped as duplicates of themselves
+	if s.indexOnly == nil || s.indexOnly.flush == nil {
+		return
+	}
+	err := s.indexOnly.flush()
+	if err != nil {
+		slog.Error("spider - Error saving index", "err", err)
+		return
+	}
+	err = s.contentHashes.persist()
+	if err != nil {
+		slog.Error("spider - Error recording content hashes", "err", err)
+	}
+}
@@ -0,0 +1,114 @@
+package spider
+
+import (
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"searchHouse/common"
+	"strings"
+)
+
+// MergeReport counts what MergePageDirs did with the stored pages
+// it found. Conflicts are pages crawled at different times in more
+// than one directory, of which only the most recent copy is kept.
+// Skipped pages are unreadable or identical copies of a kept page
+
+type MergeReport struct {
+	Merged    int `json:"merged"`
+	Skipped   int `json:"skipped"`
+	Conflicts int `json:"conflicts"`
+}
+
+// mergedPage is the copy of a page kept in the output directory
+
+type mergedPage struct {
+	path string
+	time int64
+}
+
+func MergePageDirs(dirs []string, out string) (MergeReport, error) {
+	// Copy the stored pages of every directory into out. Pages
+	// are named after the hash of their URL, so the same page
+	// crawled on two machines has the same name in both
+	var report MergeReport
+	err := os.MkdirAll(out, 0755)
+	if err != nil {
+		return report, err
+	}
+	kept := make(map[string]mergedPage)
+	// Pages already in out take part in the merge like any other
+	for _, dir := range append([]string{out}, dirs...) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return report, err
+		}
+		for _, entry := range entries {
+			if entry.IsDir() || !common.IsStoredPageName(entry.Name()) {
+				continue
+			}
+			path := filepath.Join(dir, entry.Name())
+			wp, err := common.ReadStoredPage(path)
+			if err != nil {
+				slog.Warn("spider - Skipping unreadable page", "path", path, "err", err)
+				report.Skipped++
+				continue
+			}
+			hash := strings.TrimSuffix(strings.TrimSuffix(entry.Name(), common.CompressedPageExt), common.PageExt)
+			existing, exists := kept[hash]
+			if dir == out {
+				kept[hash] = mergedPage{path: path, time: wp.Time}
+				continue
+			}
+			if exists && existing.time == wp.Time {
+				report.Skipped++
+				continue
+			}
+			if exists {
+				report.Conflicts++
+				if existing.time > wp.Time {
+					continue
+				}
+			} else {
+				report.Merged++
+			}
+			target := filepath.Join(out, entry.Name())
+			err = copyPage(path, target)
+			if err != nil {
+				return report, err
+			}
+			if exists && existing.path != target {
+				// The older copy was stored with the other extension
+				err = os.Remove(existing.path)
+				if err != nil {
+					return report, err
+				}
+			}
+			kept[hash] = mergedPage{path: target, time: wp.Time}
+		}
+	}
+	return report, nil
+}
+
+func copyPage(src string, dst string) error {
+	// Copy through a temporary file so an interrupted
+	// merge never leaves a truncated page in dst
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".tmp-merge-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, in)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
@@ -0,0 +1,82 @@
+package spider
+
+import (
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strconv"
+	"sync"
+)
+
+// Pagination of WordPress listings (archives, categories, tags and
+// search results), matched against the path and query of a URL.
+// The first group of each pattern captures the page number
+
+var DefaultPaginationPatterns = []string{
+	`/page/(\d+)/?$`,
+	`[?&]paged?=(\d+)`,
+}
+
+// paginationLimiter stops following the pages of a paginated
+// listing past maxDepth, where the listing root is the URL with
+// its page number removed (e.g. /category/news/ for
+// /category/news/page/7/). Every root past the cap is logged once
+
+type paginationLimiter struct {
+	mu       sync.Mutex
+	patterns []*regexp.Regexp
+	maxDepth int
+	capped   map[string]bool
+}
+
+func (s *SearchHouseSpider) setPagination(patterns []string, maxDepth int) error {
+	// Only follow the first maxDepth pages of each
+	// listing matched by patterns, 0 follows them all
+	if maxDepth <= 0 {
+		s.pagination = nil
+		return nil
+	}
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return err
+		}
+		compiled = append(compiled, re)
+	}
+	s.pagination = &paginationLimiter{patterns: compiled, maxDepth: maxDepth, capped: make(map[string]bool)}
+	return nil
+}
+
+func (pl *paginationLimiter) beyondCap(rawUrl string) bool {
+	if pl == nil {
+		return false
+	}
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return false
+	}
+	pathQuery := parsedUrl.Path
+	if parsedUrl.RawQuery != "" {
+		pathQuery += "?" + parsedUrl.RawQuery
+	}
+	for _, re := range pl.patterns {
+		match := re.FindStringSubmatchIndex(pathQuery)
+		if match == nil || len(match) < 4 || match[2] < 0 {
+			continue
+		}
+		page, err := strconv.Atoi(pathQuery[match[2]:match[3]])
+		if err != nil || page <= pl.maxDepth {
+			continue
+		}
+		root := parsedUrl.Host + pathQuery[:match[0]] + pathQuery[match[1]:]
+		pl.mu.Lock()
+		if !pl.capped[root] {
+			pl.capped[root] = true
+			slog.Info("spider - Pagination cap reached, no longer enqueuing pages of listing", "listing", root, "maxDepth", pl.maxDepth)
+		}
+		pl.mu.Unlock()
+		return true
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package spider
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"searchHouse/common"
+	"sort"
+)
+
+// Which page of a near-duplicate cluster PurgeDuplicates keeps
+const (
+	KeepShortest = "shortest"
+	KeepEarliest = "earliest"
+)
+
+// PurgeOptions configures PurgeDuplicates. Without Confirm nothing
+// is touched and the report only shows what would be purged. With
+// it duplicates are moved to Quarantine, or deleted if it's ""
+
+type PurgeOptions struct {
+	Threshold  float64
+	Keep       string
+	Quarantine string
+	Confirm    bool
+}
+
+// PurgeReport lists the near-duplicate clusters found in a page
+// directory, each under the URL of the page that was kept
+
+type PurgeReport struct {
+	Pages    int                `json:"pages"`
+	Purged   int                `json:"purged"`
+	Clusters []duplicateCluster `json:"clusters"`
+}
+
+// storedPage is a page read back from a page directory
+
+type storedPage struct {
+	path string
+	page *common.WebPage
+}
+
+func PurgeDuplicates(pageDir string, opts PurgeOptions) (PurgeReport, error) {
+	// Fingerprint every stored page and purge the near-duplicates
+	// of the pages kept. Pages are compared in order of preference,
+	// so the first page of a cluster to be seen is the one kept
+	report := PurgeReport{Clusters: make([]duplicateCluster, 0)}
+	if opts.Keep != KeepShortest && opts.Keep != KeepEarliest {
+		return report, fmt.Errorf("unknown page to keep %q", opts.Keep)
+	}
+	pages, err := readStoredPages(pageDir)
+	if err != nil {
+		return report, err
+	}
+	report.Pages = len(pages)
+	sort.SliceStable(pages, func(i, j int) bool {
+		left, right := pages[i].page, pages[j].page
+		if opts.Keep == KeepEarliest && left.Time != right.Time {
+			return left.Time < right.Time
+		}
+		if len(left.Url) != len(right.Url) {
+			return len(left.Url) < len(right.Url)
+		}
+		return left.Url < right.Url
+	})
+	kept := common.NewFingerprints(3, len(pages))
+	clusters := make(map[string][]string)
+	var purged []storedPage
+	for _, sp := range pages {
+		// Only fingerprint a page's body once it's its turn, only
+		// the fingerprints of the pages kept stay in memory
+		wp, err := common.ReadStoredPage(sp.path)
+		if err != nil {
+			return report, fmt.Errorf("%s: %w", sp.path, err)
+		}
+		wp.Fingerprints = common.NewFingerprints(3, 1)
+		wp.Fingerprints.InsertFingerprintsUsingWebpage(wp)
+		duplicateUrl, _ := kept.FindDuplicate(wp, opts.Threshold)
+		if duplicateUrl == "" {
+			kept.InsertFingerprintsUsingWebpage(wp)
+			wp.Body, wp.Text = "", ""
+			continue
+		}
+		clusters[duplicateUrl] = append(clusters[duplicateUrl], sp.page.Url)
+		purged = append(purged, sp)
+	}
+	for url, duplicates := range clusters {
+		report.Clusters = append(report.Clusters, duplicateCluster{Url: url, Duplicates: duplicates})
+	}
+	sort.Slice(report.Clusters, func(i, j int) bool {
+		return report.Clusters[i].Url < report.Clusters[j].Url
+	})
+	if !opts.Confirm {
+		return report, nil
+	}
+	if opts.Quarantine != "" {
+		err = os.MkdirAll(opts.Quarantine, 0755)
+		if err != nil {
+			return report, err
+		}
+	}
+	for _, sp := range purged {
+		if opts.Quarantine != "" {
+			err = os.Rename(sp.path, filepath.Join(opts.Quarantine, filepath.Base(sp.path)))
+		} else {
+			err = os.Remove(sp.path)
+		}
+		if err != nil {
+			return report, err
+		}
+		report.Purged++
+	}
+	return report, nil
+}
+
+func readStoredPages(pageDir string) ([]storedPage, error) {
+	// Read the URL and crawl time of every stored page,
+	// dropping the rest to keep memory down
+	entries, err := os.ReadDir(pageDir)
+	if err != nil {
+		return nil, err
+	}
+	pages := make([]storedPage, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() || !common.IsStoredPageName(entry.Name()) {
+			continue
+		}
+		path := filepath.Join(pageDir, entry.Name())
+		wp, err := common.ReadStoredPage(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		pages = append(pages, storedPage{path: path, page: &common.WebPage{Url: wp.Url, Time: wp.Time}})
+	}
+	return pages, nil
+}
@@ -0,0 +1,127 @@
+package spider
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// RoutineInfo is a heartbeat published by a crawl routine:
+// the URL it's working on (empty when idle) and when it
+// started working on it (or became idle)
+
+type RoutineInfo struct {
+	Routine int
+	Url     string
+	Since   time.Time
+}
+
+type routineHeartbeats struct {
+	mu     sync.Mutex
+	status []RoutineInfo
+}
+
+func newRoutineHeartbeats(numRoutines int) *routineHeartbeats {
+	rh := &routineHeartbeats{status: make([]RoutineInfo, numRoutines)}
+	rh.reset()
+	return rh
+}
+
+func (rh *routineHeartbeats) reset() {
+	// Mark every routine idle since now, when the crawl starts
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+	for i := range rh.status {
+		rh.status[i] = RoutineInfo{Routine: i, Since: time.Now()}
+	}
+}
+
+func (rh *routineHeartbeats) beat(routineNum int, url string) {
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+	if rh.status[routineNum].Url == url {
+		return
+	}
+	rh.status[routineNum] = RoutineInfo{Routine: routineNum, Url: url, Since: time.Now()}
+}
+
+func (rh *routineHeartbeats) snapshot() []RoutineInfo {
+	rh.mu.Lock()
+	defer rh.mu.Unlock()
+	return append([]RoutineInfo(nil), rh.status...)
+}
+
+func (s *SearchHouseSpider) RoutineStatus() []RoutineInfo {
+	return s.heartbeats.snapshot()
+}
+
+func (s *SearchHouseSpider) watchIdle(ctx context.Context, stop context.CancelFunc) {
+	// Stop the crawl once every routine has been idle for
+	// idleTimeout with nothing left in the frontier
+	ticker := time.NewTicker(min(s.idleTimeout, time.Second))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		idleSince := time.Time{}
+		for _, info := range s.RoutineStatus() {
+			if info.Url != "" {
+				idleSince = time.Now()
+				break
+			}
+			if info.Since.After(idleSince) {
+				idleSince = info.Since
+			}
+		}
+		if time.Since(idleSince) >= s.idleTimeout && s.frontier.Len() == 0 {
+			slog.Info("spider - Frontier empty and routines idle, stopping", "idle", s.idleTimeout)
+			stop()
+			return
+		}
+	}
+}
+
+func (s *SearchHouseSpider) logRoutineUtilization() {
+	// Report how many routines have URLs to crawl, since
+	// URLs are partitioned by host a crawl of few hosts
+	// leaves most routines idle whatever -numRoutines is
+	sizes := s.frontier.PartitionSizes()
+	busy := 0
+	for routineNum := 0; routineNum < s.numRoutines; routineNum++ {
+		if sizes[routineNum] > 0 {
+			busy++
+		}
+	}
+	slog.Info("spider - Routines with URLs queued", "busy", busy, "routines", s.numRoutines)
+	if s.numRoutines > 1 && busy*2 < s.numRoutines {
+		slog.Warn("spider - Most routines are idle, the queued URLs span too few hosts to use them all", "busy", busy, "routines", s.numRoutines)
+	}
+}
+
+func (s *SearchHouseSpider) reportStats(ctx context.Context) {
+	// Periodically log the crawl's progress along with every
+	// routine that has been stuck on the same URL for longer
+	// than the reporting interval
+	ticker := time.NewTicker(s.statsInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		stats := s.stats.Snapshot()
+		slog.Info("spider - Progress", "stored", stats.PagesStored, "bytes", stats.Bytes, "decompressed", stats.Decoded, "failures", stats.Failures)
+		s.logRoutineUtilization()
+		for _, info := range s.RoutineStatus() {
+			busy := time.Since(info.Since)
+			if info.Url != "" && busy > s.statsInterval {
+				slog.Warn("spider - Routine stuck", "routine", info.Routine, "url", info.Url, "for", busy.Round(time.Second))
+			}
+		}
+	}
+}
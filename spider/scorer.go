@@ -0,0 +1,17 @@
+package spider
+
+// URLScorer assigns a priority to a URL before it's inserted
+// into the frontier, URLs with higher scores are crawled first
+
+type URLScorer interface {
+	Score(url string, depth int) float64
+}
+
+// DepthScorer prioritizes URLs closer to the
+// seeds, i.e. a breadth-first crawl
+
+type DepthScorer struct{}
+
+func (ds DepthScorer) Score(url string, depth int) float64 {
+	return -float64(depth)
+}
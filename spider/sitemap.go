@@ -0,0 +1,119 @@
+package spider
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"searchHouse/common"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Maximum number of URLs allowed in a single
+// sitemap file by the sitemaps.org protocol
+const maxSitemapURLs = 50000
+
+const sitemapNamespace = "http://www.sitemaps.org/schemas/sitemap/0.9"
+
+type sitemapURL struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type urlSet struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	URLs    []sitemapURL `xml:"url"`
+}
+
+type sitemapEntry struct {
+	Loc string `xml:"loc"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name       `xml:"sitemapindex"`
+	Xmlns    string         `xml:"xmlns,attr"`
+	Sitemaps []sitemapEntry `xml:"sitemap"`
+}
+
+func GenerateSitemap(pageDir, host, outPath string) error {
+	// Emit a sitemap.xml of every stored page belonging to host,
+	// to be served from the root of the host
+	return GenerateSitemapWithBaseURL(pageDir, host, outPath, "https://"+host+"/")
+}
+
+func GenerateSitemapWithBaseURL(pageDir, host, outPath, baseURL string) error {
+	// Emit a sitemap.xml of every stored page belonging to host.
+	// If there are more URLs than a single sitemap may hold, they're
+	// split into numbered files and outPath becomes a sitemap index
+	// pointing to them under baseURL, where they're to be served
+	base, err := url.Parse(baseURL)
+	if err != nil {
+		return err
+	}
+	if !base.IsAbs() {
+		return fmt.Errorf("sitemap base URL %q isn't absolute", baseURL)
+	}
+	if !strings.HasSuffix(base.Path, "/") {
+		base.Path += "/"
+	}
+	urls := make([]sitemapURL, 0)
+	err = common.WalkPages(pageDir, func(wp *common.WebPage) error {
+		parsedUrl, err := url.Parse(wp.Url)
+		if err != nil || parsedUrl.Host != host {
+			return nil
+		}
+		urls = append(urls, sitemapURL{
+			Loc:     wp.Url,
+			LastMod: time.Unix(wp.Time, 0).UTC().Format(time.RFC3339),
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Slice(urls, func(i, j int) bool {
+		return urls[i].Loc < urls[j].Loc
+	})
+
+	if len(urls) <= maxSitemapURLs {
+		return writeXML(outPath, urlSet{Xmlns: sitemapNamespace, URLs: urls})
+	}
+
+	index := sitemapIndex{Xmlns: sitemapNamespace}
+	ext := filepath.Ext(outPath)
+	stem := strings.TrimSuffix(outPath, ext)
+	for i := 0; i*maxSitemapURLs < len(urls); i++ {
+		end := min((i+1)*maxSitemapURLs, len(urls))
+		partPath := fmt.Sprintf("%s-%d%s", stem, i+1, ext)
+		err = writeXML(partPath, urlSet{Xmlns: sitemapNamespace, URLs: urls[i*maxSitemapURLs : end]})
+		if err != nil {
+			return err
+		}
+		index.Sitemaps = append(index.Sitemaps, sitemapEntry{Loc: base.JoinPath(filepath.Base(partPath)).String()})
+	}
+	return writeXML(outPath, index)
+}
+
+func writeXML(path string, v any) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(xml.Header)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	encoder := xml.NewEncoder(f)
+	encoder.Indent("", "  ")
+	err = encoder.Encode(v)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
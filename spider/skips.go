@@ -0,0 +1,51 @@
+package spider
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const skippedFileName = "skipped.jsonl"
+
+// skippedURL is a line of skipped.jsonl
+
+type skippedURL struct {
+	Url       string `json:"url"`
+	Reason    string `json:"reason"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+func (s *SearchHouseSpider) setRecordSkips(enabled bool) {
+	// Append every URL or page skipped by urlValid, validPage
+	// and duplicateExists, along with the reason, to
+	// skipped.jsonl in the working directory
+	s.recordSkips = enabled
+}
+
+func (s *SearchHouseSpider) openSkipsLog() error {
+	if !s.recordSkips {
+		return nil
+	}
+	f, err := os.OpenFile(filepath.Join(s.workingDirectory, skippedFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	s.skipsLog = f
+	return nil
+}
+
+func (s *SearchHouseSpider) recordSkip(url string, reason string) {
+	if s.skipsLog == nil {
+		return
+	}
+	line, _ := json.Marshal(skippedURL{Url: url, Reason: reason, Timestamp: time.Now().Unix()})
+	s.skipsMu.Lock()
+	defer s.skipsMu.Unlock()
+	_, err := s.skipsLog.Write(append(line, '\n'))
+	if err != nil {
+		slog.Error("spider - Error recording skipped URL", "err", err)
+	}
+}
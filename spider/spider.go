@@ -1,107 +1,1651 @@
 package spider
 
 import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/json"
 	"errors"
+	"fmt"
 	lru "github.com/hashicorp/golang-lru/v2"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/publicsuffix"
+	"golang.org/x/time/rate"
 	"hash/fnv"
 	"io"
 	"log"
+	"log/slog"
+	"math"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"regexp"
 	"searchHouse/common"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 	"unicode"
 )
 
+const (
+	writeRetries    = 2
+	diskFullBackoff = time.Minute
+
+	// Idle connections outlive the politeness delay so
+	// consecutive requests to a host reuse them
+	defaultMaxIdleConnsPerHost = 2
+	defaultIdleConnTimeout     = 90 * time.Second
+	defaultCrawlDelay          = 5 * time.Second
+	defaultProbeTimeout        = 10 * time.Second
+	defaultMaxRedirects        = 10
+	defaultDialTimeout         = 30 * time.Second
+	defaultDuplicateThreshold  = 0.9
+	defaultMaxBodyBytes        = 10 << 20
+	defaultDuplicateWindow     = 10000
+	defaultMaxCrawlDelay       = time.Minute
+	defaultMaxRetries          = 3
+	defaultMaxURLLength        = 2048
+	defaultIdleBackoff         = time.Second
+	defaultMaxIdleBackoff      = 30 * time.Second
+	// Longest meta refresh delay treated as a redirect, longer
+	// ones are usually pages reloading themselves
+	maxMetaRefreshDelay = 5
+)
+
+// Waits out the backoff between page write attempts, replaced in tests
+var sleepBeforeRetry = time.Sleep
+
+// Algorithms used to fingerprint pages for near-duplicate detection
+const (
+	ShingleAlgo = "shingle"
+	SimHashAlgo = "simhash"
+)
+
+type redirectChainKey struct{}
+
+var errPrivateAddress = errors.New("refusing to connect to private address")
+
+var errBodyTooLarge = errors.New("body too large")
+
+// Accept header sent with crawl requests, preferring HTML
+// over the other representations a CDN might negotiate
+const DefaultAccept = "text/html,application/xhtml+xml;q=0.9,*/*;q=0.8"
+
+// Paths probed to detect WordPress, wp-json catches
+// hardened installs that block or move wp-admin
+var DefaultWordPressProbePaths = []string{"/wp-admin", "/wp-json"}
+
+// Reason given for URLs of hosts whose WordPress probe was throttled,
+// they're requeued until the probe gets a real answer
+const wordPressUnknown = "WordPress detection throttled"
+
+// URL patterns of WordPress pages that are transactional,
+// administrative or search results rather than content, and
+// of well-known files and endpoints that are never content
+var DefaultExcludePatterns = []string{
+	`/wp-admin/`,
+	`/cart/?$`,
+	`/feed/?$`,
+	`[?&]s=`,
+	`/xmlrpc\.php`,
+	`/wp-cron\.php`,
+	`/wp-login\.php`,
+	`/\.well-known/`,
+	`/(app-)?ads\.txt$`,
+	`/security\.txt$`,
+}
+
+// Per-request noise stripped from bodies before duplicate detection:
+// nonce and CSRF fields, tracking and session parameters in links,
+// and comments with timestamps left by caching plugins
+var DefaultBodyNoisePatterns = []string{
+	`(?i)name=["']?[\w-]*(nonce|csrf|token)[\w-]*["']?\s+value=["'][^"']*["']`,
+	`(?i)["']?[\w-]*(nonce|csrf)[\w-]*["']?\s*:\s*["'][^"']*["']`,
+	`(?i)[?&](fbclid|gclid|utm_[a-z]+|_wpnonce|phpsessid|sessionid|sid)=[^&"'\s<>]*`,
+	`<!--[^>]*\d{4}-\d{2}-\d{2}[ T]\d{2}:\d{2}(:\d{2})?[^>]*-->`,
+}
+
+// Scheme and host of a URL, compiled once since every URL
+// enqueued or repartitioned is assigned a routine by it
+var domainRe = regexp.MustCompile(`https://[^\s:/@]+\.[^\s:/@]+`)
+
+// Paths WordPress serves its RSS and Atom feeds at
+var feedRe = regexp.MustCompile(`/feed(/(rss2?|atom|rdf))?/?$`)
+
 type SearchHouseSpider struct {
-	numRoutines      int
-	frontier         Frontier
-	workingDirectory string
-	maxLinksPerPage  int
-	ioMu             *sync.Mutex
-	wordpressSites   *lru.Cache[string, bool]
+	config             Config
+	numRoutines        int
+	frontier           Frontier
+	workingDirectory   string
+	maxLinksPerPage    int
+	ioMu               *sync.Mutex
+	wordpressSites     *lru.Cache[string, bool]
+	wpRetryAt          map[string]time.Time
+	wpRetryMu          sync.Mutex
+	rfc3339Dates       bool
+	transport          *http.Transport
+	client             *http.Client
+	noFollow           bool
+	sameHostAsSeed     bool
+	seedHosts          StringSet
+	crawlDelay         time.Duration
+	requireWordPress   bool
+	traps              *TrapDetector
+	seeds              []string
+	seedPriorities     map[string]float64
+	hostPriorities     map[string]float64
+	probeSeeds         bool
+	probeTimeout       time.Duration
+	recordRedirects    bool
+	maxRedirects       int
+	includeSubdomains  bool
+	seedDomains        StringSet
+	scorer             URLScorer
+	excludePatterns    []*regexp.Regexp
+	dialer             *net.Dialer
+	hostOverrides      map[string]string
+	globalLimiter      *rate.Limiter
+	perHostRPS         float64
+	hostLimiters       map[string]*rate.Limiter
+	hostLimitersMu     sync.Mutex
+	maxPerHost         int
+	hostSlots          map[string]chan struct{}
+	hostSlotsMu        sync.Mutex
+	sendReferer        bool
+	stats              *CrawlStats
+	failuresLog        *os.File
+	failuresMu         sync.Mutex
+	recordSkips        bool
+	skipsLog           *os.File
+	skipsMu            sync.Mutex
+	onlyNew            bool
+	hostConfigs        *hostConfigs
+	maxDuration        time.Duration
+	duplicateAlgo      string
+	duplicateThreshold float64
+	traceTimings       bool
+	paused             atomic.Bool
+	followFeeds        bool
+	storeFeeds         bool
+	compress           bool
+	heartbeats         *routineHeartbeats
+	statsInterval      time.Duration
+	skipQueryParams    StringSet
+	onPageStored       OnPageStored
+	onPageStoredAsync  bool
+	canonicals         map[string]string
+	canonicalsMu       sync.Mutex
+	hostForms          *hostForms
+	maxBodyBytes       int64
+	externalOneHop     bool
+	lowercasePaths     bool
+	accept             AcceptFunc
+	duplicateWindow    int
+	storeRefreshStubs  bool
+	maxCrawlDelay      time.Duration
+	maxRetries         int
+	loadContentHashes  bool
+	downloaded         *BloomFilter
+	idleTimeout        time.Duration
+	wpProbePaths       []string
+	pathPrefixes       []string
+	languages          []string
+	hashFunc           HashFunc
+	dedup              *dedupReport
+	recordCrawlOrder   bool
+	storeImages        bool
+	fetchSequence      atomic.Int64
+	checkpointInterval time.Duration
+	checkpointConfig   map[string][]string
+	acceptHeader       string
+	deadHosts          *deadHosts
+	discovered         *discoveredSink
+	maxURLLength       int
+	idleBackoff        time.Duration
+	maxIdleBackoff     time.Duration
+	acceptEncoding     string
+	recordNon200       bool
+	non200             *non200Log
+	userAgent          string
+	hostUserAgents     map[string]string
+	hostHeader         string
+	hostHeaders        map[string]string
+	diskBudget         *diskBudget
+	bodyNoise          []*regexp.Regexp
+	maxFingerprints    int
+	followAlternates   bool
+	ampMode            string
+	indexOnly          *indexedPages
+	pagination         *paginationLimiter
+	contentHashes      *contentHashes
+	refreshStubs       StringSet
+	refreshStubsMu     sync.Mutex
+	sampleRate         float64
+}
+
+type runManifest struct {
+	Start  string     `json:"start"`
+	End    string     `json:"end"`
+	Seeds  []string   `json:"seeds"`
+	Stats  CrawlStats `json:"stats"`
+	Config Config     `json:"config"`
+}
+
+type failedFetch struct {
+	Time     int64  `json:"time"`
+	Url      string `json:"url"`
+	Category string `json:"category"`
+	Error    string `json:"error"`
+}
+
+// OnPageStored is called with every page after it's written to disk.
+// It's called from every crawl routine, so it must be safe to call
+// concurrently. Returned errors are logged and otherwise ignored.
+
+type OnPageStored func(page *common.WebPage) error
+
+// AcceptFunc decides whether a fetched page is stored, returning
+// the reason it was rejected for logging. Like OnPageStored it's
+// called concurrently from every crawl routine
+
+type AcceptFunc func(page *common.WebPage) (bool, string)
+
+// HashFunc hashes URLs into stored page file names, hostnames into
+// crawl routines and bodies into content hashes. Changing it orphans
+// the pages and content hashes stored with the previous one
+
+type HashFunc func(str string) uint64
+
+func NewSpider(config Config) (*SearchHouseSpider, error) {
+	ioMu := new(sync.Mutex)
+	scorer, accept := config.Scorer, config.Accept
+	if scorer == nil {
+		scorer = DepthScorer{}
+	}
+	if accept == nil {
+		accept = AcceptHTML
+	}
+	wpCache, _ := lru.New[string, bool](1000)
+	// The idle connection limits are set by configure
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	cs := SearchHouseSpider{
+		config:             config,
+		numRoutines:        config.NumRoutines,
+		workingDirectory:   config.PageDir,
+		maxLinksPerPage:    config.MaxLinks,
+		ioMu:               ioMu,
+		wordpressSites:     wpCache,
+		wpRetryAt:          make(map[string]time.Time),
+		transport:          transport,
+		crawlDelay:         defaultCrawlDelay,
+		requireWordPress:   true,
+		seeds:              config.Seeds,
+		seedPriorities:     config.SeedPriorities,
+		probeTimeout:       defaultProbeTimeout,
+		maxRedirects:       defaultMaxRedirects,
+		scorer:             scorer,
+		dialer:             &net.Dialer{Timeout: defaultDialTimeout, KeepAlive: 30 * time.Second},
+		hostLimiters:       make(map[string]*rate.Limiter),
+		hostSlots:          make(map[string]chan struct{}),
+		stats:              NewCrawlStats(),
+		hostConfigs:        newHostConfigs(make(map[string]HostConfig)),
+		duplicateAlgo:      ShingleAlgo,
+		duplicateThreshold: defaultDuplicateThreshold,
+		hostForms:          newHostForms(),
+		maxBodyBytes:       defaultMaxBodyBytes,
+		accept:             accept,
+		onPageStored:       config.OnPageStored,
+		duplicateWindow:    defaultDuplicateWindow,
+		maxCrawlDelay:      defaultMaxCrawlDelay,
+		maxRetries:         defaultMaxRetries,
+		wpProbePaths:       DefaultWordPressProbePaths,
+		hashFunc:           FNVHash,
+		acceptHeader:       DefaultAccept,
+		deadHosts:          newDeadHosts(0),
+		maxURLLength:       defaultMaxURLLength,
+		idleBackoff:        defaultIdleBackoff,
+		maxIdleBackoff:     defaultMaxIdleBackoff,
+		acceptEncoding:     DefaultAcceptEncoding,
+		dedup:              newDedupReport(),
+		canonicals:         make(map[string]string),
+	}
+	transport.DialContext = cs.dialContext
+	cs.client = &http.Client{Transport: transport, CheckRedirect: cs.checkRedirect}
+	err := cs.configure(config)
+	if err != nil {
+		return nil, err
+	}
+	// After configure, which may change the number of routines
+	cs.heartbeats = newRoutineHeartbeats(cs.numRoutines)
+	cs.frontier.Init()
+	return &cs, nil
+}
+
+func (s *SearchHouseSpider) setRFC3339Dates(enabled bool) {
+	// Store an RFC3339 date alongside the epoch
+	// timestamp of every page written to disk
+	s.rfc3339Dates = enabled
+}
+
+func (s *SearchHouseSpider) setWWWCanonicalization(enabled bool) {
+	// Rewrite URLs to the www. or apex form of their host
+	// once a redirect between the two shows which it prefers
+	if enabled {
+		s.hostForms = newHostForms()
+	} else {
+		s.hostForms = nil
+	}
+}
+
+func (s *SearchHouseSpider) setConnectionReuse(maxIdleConnsPerHost int, idleConnTimeout time.Duration) {
+	// Tune how many idle keep-alive connections are kept
+	// per host and how long they survive between requests
+	s.transport.MaxIdleConnsPerHost = maxIdleConnsPerHost
+	s.transport.IdleConnTimeout = idleConnTimeout
+}
+
+func (s *SearchHouseSpider) setProtocols(forceHTTP1 bool, h2c bool) {
+	// forceHTTP1 stops HTTP/2 from being negotiated with https
+	// hosts, h2c speaks HTTP/2 without TLS to http:// URLs for
+	// testing against local servers that support it
+	if forceHTTP1 {
+		s.transport.ForceAttemptHTTP2 = false
+		// A non-nil empty map disables the bundled HTTP/2 support
+		s.transport.TLSNextProto = make(map[string]func(string, *tls.Conn) http.RoundTripper)
+		if config := s.transport.TLSClientConfig; config != nil {
+			config.NextProtos = slices.DeleteFunc(config.NextProtos, func(proto string) bool {
+				return proto == "h2"
+			})
+		}
+	}
+	if h2c {
+		s.transport.RegisterProtocol("http", &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network string, addr string, _ *tls.Config) (net.Conn, error) {
+				return s.dialContext(ctx, network, addr)
+			},
+		})
+	}
+}
+
+func (s *SearchHouseSpider) setNoFollow(enabled bool) {
+	// Only fetch and store the seeded URLs without expanding
+	// the frontier from their links, exiting once they're done
+	s.noFollow = enabled
+}
+
+func (s *SearchHouseSpider) setPathPrefixes(prefixes []string) {
+	// Only crawl the URLs of seed hosts under one of the path
+	// prefixes, e.g. /docs/, unless the host's HostConfig has
+	// its own. Other hosts are left to the host scoping
+	s.pathPrefixes = prefixes
+}
+
+func (s *SearchHouseSpider) setHashFunc(hashFunc HashFunc) {
+	// Replace the FNV-1a hash, e.g. so tests can predict
+	// file names and routines. nil restores the default
+	if hashFunc == nil {
+		hashFunc = FNVHash
+	}
+	s.hashFunc = hashFunc
+}
+
+func (s *SearchHouseSpider) setSameHostAsSeed(enabled bool) {
+	// Restrict the crawl to the hosts of the seed URLs
+	s.sameHostAsSeed = enabled
+}
+
+func (s *SearchHouseSpider) setCrawlDelay(delay time.Duration) {
+	// Delay between consecutive requests of a routine. A delay
+	// of 0 disables politeness entirely and should only be used
+	// against servers you own
+	s.crawlDelay = delay
+}
+
+func (s *SearchHouseSpider) setRequireWordPress(enabled bool) {
+	// Disabling this skips the wp-admin probe and
+	// accepts pages from any website
+	s.requireWordPress = enabled
+}
+
+func (s *SearchHouseSpider) setWordPressProbePaths(paths []string) {
+	// Paths tried in order to detect WordPress, for installs
+	// that relocate or block wp-admin. The result is cached
+	// per host regardless of which path answered
+	s.wpProbePaths = paths
+}
+
+func (s *SearchHouseSpider) setTrapDetection(patterns []string, threshold int) error {
+	// Stop enqueuing variants of a templated path once more
+	// than threshold of them were seen on a host, 0 disables
+	if threshold <= 0 {
+		s.traps = nil
+		return nil
+	}
+	traps, err := NewTrapDetector(patterns, threshold)
+	if err != nil {
+		return err
+	}
+	s.traps = traps
+	return nil
+}
+
+func (s *SearchHouseSpider) ExportFrontier(w io.Writer) error {
+	return s.frontier.Export(w)
+}
+
+func (s *SearchHouseSpider) ImportFrontier(r io.Reader) error {
+	// Imported URLs are re-partitioned to this spider's routines
+	return s.frontier.Import(r, s.calcWebsiteToRoutineNum)
+}
+
+func (s *SearchHouseSpider) setSeedProbe(enabled bool, timeout time.Duration) {
+	// Check that each seed host is reachable before crawling
+	// begins, dropping the seeds of hosts that aren't
+	s.probeSeeds = enabled
+	s.probeTimeout = timeout
+}
+
+func (s *SearchHouseSpider) setLowercasePaths(enabled bool) {
+	// Treat URL paths as case-insensitive, only safe
+	// for servers that ignore the case of paths
+	s.lowercasePaths = enabled
+}
+
+func (s *SearchHouseSpider) setFollowExternalOneHop(enabled bool) {
+	// Crawl the seed sites fully plus the external pages they
+	// link to, without following the links of external pages
+	s.externalOneHop = enabled
+}
+
+func (s *SearchHouseSpider) setIncludeSubdomains(enabled bool) {
+	// Restrict the crawl to hosts sharing a registered domain
+	// (eTLD+1) with a seed, e.g. blog.example.com for example.com
+	s.includeSubdomains = enabled
+}
+
+func (s *SearchHouseSpider) setExcludePatterns(patterns []string) error {
+	// Reject URLs matching any of the given regexes
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return err
+		}
+		compiled = append(compiled, re)
+	}
+	s.excludePatterns = compiled
+	return nil
+}
+
+func (s *SearchHouseSpider) setBodyNoisePatterns(patterns []string) error {
+	// Strip matches of the given regexes from bodies before
+	// they're hashed and fingerprinted for duplicate detection
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return err
+		}
+		compiled = append(compiled, re)
+	}
+	s.bodyNoise = compiled
+	return nil
+}
+
+func (s *SearchHouseSpider) setRedirects(record bool, maxRedirects int) {
+	// Limit the number of redirects followed per request and
+	// optionally record each hop of the chain on the page
+	s.recordRedirects = record
+	s.maxRedirects = maxRedirects
+}
+
+func (s *SearchHouseSpider) setDialer(dnsServer string, dialTimeout time.Duration, hostOverrides map[string]string) {
+	// Resolve hostnames using a specific DNS server (host:port)
+	// instead of the system's, and/or pin hosts to addresses
+	s.dialer.Timeout = dialTimeout
+	s.hostOverrides = hostOverrides
+	for host, address := range hostOverrides {
+		slog.Debug("spider - Address override", "host", host, "address", address)
+	}
+	if dnsServer != "" {
+		s.dialer.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+				d := net.Dialer{Timeout: dialTimeout}
+				return d.DialContext(ctx, network, dnsServer)
+			},
+		}
+	}
+}
+
+func (s *SearchHouseSpider) setRateLimits(globalRPS float64, perHostRPS float64) {
+	// Cap the requests per second made across all routines
+	// and/or to any single host, 0 leaves the rate unlimited
+	s.globalLimiter = nil
+	if globalRPS > 0 {
+		s.globalLimiter = rate.NewLimiter(rate.Limit(globalRPS), 1)
+	}
+	s.perHostRPS = perHostRPS
+}
+
+func (s *SearchHouseSpider) setMaxPerHost(maxRequests int) {
+	// Cap the requests in flight to any single host, unlike the
+	// rate limits this bounds parallelism rather than spacing,
+	// 0 leaves it unlimited. Every host is crawled by a single
+	// routine, so its crawl requests never overlap: what overlaps
+	// them are the WordPress and seed probes of the host made by
+	// other routines when they find links to it
+	s.maxPerHost = maxRequests
+}
+
+func (s *SearchHouseSpider) setSendReferer(enabled bool) {
+	// Send the URL of the page a link was found on as the
+	// Referer header, some sites gate content behind it
+	s.sendReferer = enabled
+}
+
+func (s *SearchHouseSpider) setFailuresFile(path string) error {
+	// Append every failed fetch along with its
+	// category to path as a line of JSON
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	s.failuresLog = f
+	return nil
+}
+
+func (s *SearchHouseSpider) setOnlyNew(enabled bool) {
+	// Only enqueue URLs that are neither stored nor already
+	// in the persisted frontier, counting new versus known URLs
+	s.onlyNew = enabled
+}
+
+func (s *SearchHouseSpider) setHostConfigs(configs map[string]HostConfig) {
+	// Override the crawl delay, depth limit and
+	// page cap of specific hosts
+	s.hostConfigs = newHostConfigs(configs)
+}
+
+func (s *SearchHouseSpider) setMaxDuration(d time.Duration) {
+	// Stop the crawl once it has run for d, 0 runs forever
+	s.maxDuration = d
+}
+
+func (s *SearchHouseSpider) setDuplicateDetection(algo string, threshold float64, simHashDistance int) error {
+	// Fingerprint pages either as sets of shingles (ShingleAlgo)
+	// or a single SimHash (SimHashAlgo), which uses far less memory.
+	// Pages more than threshold similar are considered duplicates,
+	// or SimHashes at most simHashDistance bits apart when it's set
+	if algo != ShingleAlgo && algo != SimHashAlgo {
+		return fmt.Errorf("unknown fingerprint algorithm %q", algo)
+	}
+	if simHashDistance < 0 || simHashDistance > 64 {
+		return fmt.Errorf("SimHash distance %d outside 0-64", simHashDistance)
+	}
+	if simHashDistance > 0 {
+		if algo != SimHashAlgo {
+			return fmt.Errorf("a SimHash distance needs the %s fingerprint algorithm", SimHashAlgo)
+		}
+		threshold = common.SimHashThreshold(simHashDistance)
+	}
+	s.duplicateAlgo = algo
+	s.duplicateThreshold = threshold
+	return nil
+}
+
+func (s *SearchHouseSpider) setMaxFingerprints(maxHashes int) {
+	// Keep a MinHash sketch of at most maxHashes shingle
+	// fingerprints per page, 0 keeps all of them
+	s.maxFingerprints = maxHashes
+}
+
+func (s *SearchHouseSpider) setDuplicateWindow(pages int) {
+	// Only remember the fingerprints of the last pages stored
+	// by each routine, bounding memory on long crawls at the
+	// cost of missing near-duplicates stored longer ago
+	s.duplicateWindow = pages
+}
+
+func (s *SearchHouseSpider) setIdleTimeout(timeout time.Duration) {
+	// Stop crawling once the frontier has been empty and every
+	// routine idle for timeout, 0 waits for new URLs forever
+	s.idleTimeout = timeout
+}
+
+func (s *SearchHouseSpider) setLoadContentHashes(enabled bool) {
+	// Load the hashes of pages stored by earlier runs so pages
+	// byte-identical to them are skipped, not only to this run's
+	s.loadContentHashes = enabled
+}
+
+func (s *SearchHouseSpider) setMaxCrawlDelay(delay time.Duration) {
+	// Cap the delays hosts ask for, so a huge Retry-After
+	// can't freeze a routine, 0 disables the cap
+	s.maxCrawlDelay = delay
+}
+
+func (s *SearchHouseSpider) setMaxRetries(retries int) {
+	// Requeue a URL answered with a Retry-After at most this
+	// many times, so a host that never stops throttling can't
+	// keep it in the frontier forever
+	s.maxRetries = retries
+}
+
+func (s *SearchHouseSpider) setStoreRefreshStubs(enabled bool) {
+	// Store pages that meta refresh to another URL
+	// as well as following them
+	s.storeRefreshStubs = enabled
+}
+
+func (s *SearchHouseSpider) setTraceTimings(enabled bool) {
+	// Log the DNS, connect, TLS and time to first
+	// byte timings of every fetch
+	s.traceTimings = enabled
+}
+
+func (s *SearchHouseSpider) setRecordCrawlOrder(enabled bool) {
+	// Store on every page how long its GET took and its
+	// sequence number, counting the fetches of this run
+	s.recordCrawlOrder = enabled
+}
+
+func (s *SearchHouseSpider) setStoreImages(enabled bool) {
+	// Store on every page the absolute URLs of its
+	// images, up to the maximum number of links
+	s.storeImages = enabled
+}
+
+func (s *SearchHouseSpider) setIdleBackoff(initial time.Duration, maximum time.Duration) {
+	// A routine with nothing to crawl waits initial before
+	// checking its partition again, doubling the wait every
+	// time it's still empty up to maximum
+	s.idleBackoff = max(initial, time.Millisecond)
+	s.maxIdleBackoff = max(maximum, s.idleBackoff)
+}
+
+func (s *SearchHouseSpider) setMaxURLLength(length int) {
+	// Reject URLs longer than length, usually generated
+	// by crawl traps stacking query parameters. 0 allows any
+	s.maxURLLength = length
+}
+
+func (s *SearchHouseSpider) setUserAgents(userAgent string, hostUserAgents map[string]string) {
+	// The User-Agent of crawl requests, "" keeps Go's, and the
+	// overrides for the hosts that need another, keyed by host
+	s.userAgent = userAgent
+	s.hostUserAgents = make(map[string]string, len(hostUserAgents))
+	for host, hostUserAgent := range hostUserAgents {
+		s.hostUserAgents[strings.ToLower(host)] = hostUserAgent
+		slog.Debug("spider - User-Agent override", "host", host, "userAgent", hostUserAgent)
+	}
+}
+
+func (s *SearchHouseSpider) setHostHeaders(hostHeader string, hostHeaders map[string]string) {
+	// The Host header sent in place of the URL's host, "" sends
+	// the URL's, and the overrides for specific hosts, keyed by
+	// the host of the URL. Connections still go to the URL's host
+	s.hostHeader = hostHeader
+	if hostHeader != "" {
+		slog.Debug("spider - Host header override", "hostHeader", hostHeader)
+	}
+	s.hostHeaders = make(map[string]string, len(hostHeaders))
+	for host, hostHeader := range hostHeaders {
+		s.hostHeaders[strings.ToLower(host)] = hostHeader
+		slog.Debug("spider - Host header override", "host", host, "hostHeader", hostHeader)
+	}
+}
+
+func (s *SearchHouseSpider) setAcceptHeader(accept string) {
+	// The Accept header of crawl requests, "" sends none
+	s.acceptHeader = accept
+}
+
+func (s *SearchHouseSpider) setFeeds(follow bool, store bool) {
+	// Enqueue the posts linked from RSS and Atom feeds,
+	// optionally storing the feed documents themselves
+	s.followFeeds = follow
+	s.storeFeeds = store
+}
+
+func (s *SearchHouseSpider) setAllowPrivate(enabled bool) {
+	// Allow connecting to loopback, private and link-local
+	// addresses, which are refused by default so seeds from
+	// untrusted input can't reach internal services
+	if enabled {
+		s.dialer.Control = nil
+	} else {
+		s.dialer.Control = guardPrivateAddress
+	}
+}
+
+func (s *SearchHouseSpider) setDeterministic(enabled bool) {
+	// Crawl with a single routine popping URLs in insertion
+	// order without delays so the crawl order is reproducible
+	s.frontier.SetFIFO(enabled)
+	if enabled {
+		s.numRoutines = 1
+		s.crawlDelay = 0
+	}
+}
+
+func (s *SearchHouseSpider) setMaxBodyBytes(maxBytes int64) {
+	// Reject pages larger than maxBytes, 0 is unlimited
+	s.maxBodyBytes = maxBytes
+}
+
+func (s *SearchHouseSpider) setCompress(enabled bool) {
+	// Store pages gzipped as .json.gz
+	s.compress = enabled
+}
+
+func (s *SearchHouseSpider) setStatsInterval(interval time.Duration) {
+	// Log the crawl's progress and stuck routines
+	// every interval, 0 disables the reports
+	s.statsInterval = interval
+}
+
+func (s *SearchHouseSpider) setSkipQueryParams(params []string) {
+	// Reject URLs bearing any of the given query parameters,
+	// e.g. paged for query-string pagination
+	var skip StringSet
+	for _, param := range params {
+		skip.Add(param)
+	}
+	s.skipQueryParams = skip
+}
+
+func (s *SearchHouseSpider) setOnPageStoredAsync(enabled bool) {
+	// Call OnPageStored on its own goroutine instead of
+	// blocking the routine that stored the page
+	s.onPageStoredAsync = enabled
+}
+
+func (s *SearchHouseSpider) setSampleRate(rate float64) error {
+	// Only enqueue this fraction (0.0-1.0) of discovered links.
+	// Links are picked by their hash so reruns sample the same
+	// ones, and seeds are always kept. Sampling reduces coverage
+	// but not the request rate to any host
+	if !(rate >= 0 && rate <= 1) {
+		return fmt.Errorf("sample rate %v is not between 0 and 1", rate)
+	}
+	s.sampleRate = rate
+	return nil
+}
+
+func (s *SearchHouseSpider) Pause() {
+	// Stop routines from popping new URLs, fetches
+	// in progress finish and the frontier is kept
+	if !s.paused.Swap(true) {
+		slog.Info("spider - Crawl paused")
+	}
+}
+
+func (s *SearchHouseSpider) Resume() {
+	if s.paused.Swap(false) {
+		slog.Info("spider - Crawl resumed")
+	}
+}
+
+func (s *SearchHouseSpider) Stats() CrawlStats {
+	return s.stats.Snapshot()
+}
+
+func (s *SearchHouseSpider) CrawlConcurrently(ctx context.Context) {
+	// Routines run until ctx is cancelled (or, with -noFollow,
+	// until the seeds are exhausted), finishing their current
+	// fetch before exiting
+	start := time.Now()
+	// Logged as the JSON of the manifest, which leaves out the functions
+	config, err := json.Marshal(s.config)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	slog.Info("spider - Starting crawl", "config", json.RawMessage(config))
+	if s.maxDuration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.maxDuration)
+		defer cancel()
+	}
+	seeds := s.seeds
+	if s.probeSeeds {
+		seeds = s.reachableSeeds(seeds)
+	}
+	err = os.MkdirAll(s.workingDirectory, 0755)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	s.removeStaleTempFiles()
+	// Only a crawl assigns URLs to routines, exporting or
+	// importing the frontier leaves their assignment alone
+	moved, err := s.frontier.Repartition(s.calcWebsiteToRoutineNum)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if moved > 0 {
+		slog.Info("spider - Repartitioned frontier for the number of routines", "routines", s.numRoutines, "moved", moved)
+	}
+	s.loadDownloaded()
+	err = s.openSkipsLog()
+	if err != nil {
+		slog.Error("spider - Error opening skipped URLs log", "err", err)
+	}
+	err = s.openNon200Log()
+	if err != nil {
+		slog.Error("spider - Error opening non-200 responses log", "err", err)
+	}
+	s.contentHashes, err = openContentHashes(filepath.Join(s.workingDirectory, contentHashesFileName), s.loadContentHashes)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	s.contentHashes.deferred = s.indexOnly != nil && s.indexOnly.flush != nil
+	s.setSeed(seeds)
+	if len(seeds) > 0 && !s.anyCrawlable(seeds) {
+		log.Fatalf("spider - None of the %d seeds can be crawled (see the reasons above), exiting\n", len(seeds))
+	}
+	s.logRoutineUtilization()
+	s.heartbeats.reset()
+	if s.idleTimeout > 0 {
+		var stop context.CancelFunc
+		ctx, stop = context.WithCancel(ctx)
+		defer stop()
+		go s.watchIdle(ctx, stop)
+	}
+	if s.diskBudget != nil {
+		var stop context.CancelFunc
+		ctx, stop = context.WithCancel(ctx)
+		defer stop()
+		s.diskBudget.stop = stop
+	}
+	if s.statsInterval > 0 {
+		reportCtx, stopReports := context.WithCancel(ctx)
+		defer stopReports()
+		go s.reportStats(reportCtx)
+	}
+	if s.checkpointInterval > 0 {
+		checkpointCtx, stopCheckpoints := context.WithCancel(ctx)
+		defer stopCheckpoints()
+		go s.checkpointPeriodically(checkpointCtx)
+	}
+	wg := new(sync.WaitGroup)
+	wg.Add(s.numRoutines)
+	for i := 0; i < s.numRoutines; i++ {
+		go s.Crawl(ctx, i, wg)
+	}
+	wg.Wait()
+	stats := s.stats.Snapshot()
+	slog.Info("spider - Crawl finished", "stored", stats.PagesStored, "bytes", stats.Bytes, "decompressed", stats.Decoded, "failures", stats.Failures)
+	if s.onlyNew {
+		slog.Info("spider - Discovered URLs", "new", stats.NewURLs, "known", stats.KnownURLs)
+	}
+	if s.failuresLog != nil {
+		s.failuresLog.Close()
+	}
+	if s.skipsLog != nil {
+		s.skipsLog.Close()
+	}
+	s.non200.close()
+	s.discovered.close()
+	s.flushIndex()
+	err = s.contentHashes.close()
+	if err != nil {
+		slog.Error("spider - Error saving content hashes", "err", err)
+	}
+	err = s.writeManifest(start, time.Now(), stats)
+	if err != nil {
+		slog.Error("spider - Error writing run manifest", "err", err)
+	}
+	err = s.writeDedupReport()
+	if err != nil {
+		slog.Error("spider - Error writing deduplication report", "err", err)
+	}
+	if s.checkpointInterval > 0 {
+		err = s.writeCheckpoint()
+		if err != nil {
+			slog.Error("spider - Error writing checkpoint", "err", err)
+		}
+	}
+}
+
+func (s *SearchHouseSpider) writeManifest(start time.Time, end time.Time, stats CrawlStats) error {
+	// Record what was crawled and how it went next to the pages
+	b, err := json.MarshalIndent(runManifest{
+		Start:  start.UTC().Format(time.RFC3339),
+		End:    end.UTC().Format(time.RFC3339),
+		Seeds:  s.seeds,
+		Stats:  stats,
+		Config: s.config,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.workingDirectory, "manifest.json"), b, 0666)
+}
+
+func (s *SearchHouseSpider) Crawl(ctx context.Context, routineNum int, wg *sync.WaitGroup) {
+	defer wg.Done()
+	fp := s.newDuplicateDetector()
+	backoff := s.idleBackoff
+	for ctx.Err() == nil {
+		if s.paused.Load() {
+			s.sleep(ctx, time.Second)
+			continue
+		}
+		entry := s.frontier.PopEntry(routineNum)
+		currentUrl := entry.Url
+		s.heartbeats.beat(routineNum, currentUrl)
+		if currentUrl == "" {
+			if s.noFollow {
+				// Nothing else gets enqueued, so an empty partition means we're done
+				slog.Info("spider - Routine exhausted its seeds, exiting", "routine", routineNum)
+				return
+			}
+			s.sleep(ctx, backoff)
+			backoff = min(2*backoff, s.maxIdleBackoff)
+			continue
+		}
+		backoff = s.idleBackoff
+		hostname := s.getHostname(currentUrl)
+		if reason := s.invalidURLReason(currentUrl); reason == wordPressUnknown {
+			// Retry once the host is done throttling the probe
+			s.retry(entry, routineNum)
+			s.sleep(ctx, s.hostConfigs.delay(hostname, s.crawlDelay))
+			continue
+		} else if reason != "" {
+			s.recordSkip(currentUrl, reason)
+			continue
+		}
+		if s.hostConfigs.capReached(hostname) {
+			continue
+		}
+		if !s.pageDownloaded(currentUrl) {
+			s.crawlPage(routineNum, entry, fp)
+			s.heartbeats.beat(routineNum, "")
+			s.sleep(ctx, s.hostConfigs.delay(hostname, s.crawlDelay))
+		}
+	}
+}
+
+func (s *SearchHouseSpider) sleep(ctx context.Context, d time.Duration) {
+	// Sleep for d, waking up early if ctx is cancelled
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
+
+func (s *SearchHouseSpider) crawlPage(routineNum int, entry FrontierEntry, fp common.DuplicateDetector) {
+	// Fetch, validate and store a single page,
+	// then enqueue the links found on it
+	currentUrl := entry.Url
+	sequence := s.fetchSequence.Add(1)
+	hostname := s.getHostname(currentUrl)
+	resp, redirects, latency, err := s.fetch(currentUrl, entry.Referer)
+	if err != nil {
+		s.recordFailure(currentUrl, classifyFetchError(err), err)
+		s.deadHosts.failed(hostname)
+		return
+	}
+	if resp.Status != "200 OK" {
+		// Drain the body so the keep-alive connection can be reused
+		n, _ := io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		s.stats.RecordBytes(n, 0)
+		s.recordFailure(currentUrl, classifyStatus(resp.StatusCode), errors.New(resp.Status))
+		s.non200.record(currentUrl, resp.StatusCode)
+		// Only server errors count towards giving up on the host,
+		// a throttling host is retried and broken links are the
+		// page's problem, not the host's
+		if s.honorRetryAfter(resp) {
+			s.retry(entry, routineNum)
+		} else if resp.StatusCode >= 500 {
+			s.deadHosts.failed(hostname)
+		} else {
+			s.deadHosts.succeeded(hostname)
+		}
+		return
+	}
+	s.deadHosts.succeeded(hostname)
+	body, err := s.readBody(resp)
+	if err != nil {
+		category := classifyFetchError(err)
+		if errors.Is(err, errBodyTooLarge) {
+			category = FailureTooLarge
+		} else {
+			s.deadHosts.failed(hostname)
+		}
+		s.recordFailure(currentUrl, category, err)
+		return
+	}
+	page := common.NewWebPage(time.Now().Unix(), currentUrl, resp.Status, string(body))
+	page.ContentLength = int64(len(body))
+	if s.recordRedirects {
+		page.Redirects = redirects
+	}
+	if s.recordCrawlOrder {
+		page.FetchMillis = latency.Milliseconds()
+		page.Sequence = sequence
+	}
+	if s.rfc3339Dates {
+		page.SetRFC3339Date()
+	}
+	if s.followFeeds && common.IsFeedContentType(resp.Header.Get("Content-Type")) {
+		s.crawlFeed(routineNum, entry, page)
+		return
+	}
+	if delay, target := page.FindMetaRefresh(); target != "" && delay <= maxMetaRefreshDelay {
+		if s.followMetaRefresh(entry, target) && !s.storeRefreshStubs {
+			return
+		}
+	}
+	page.Alternates = s.findAlternates(page)
+	s.findAMP(page)
+	if s.skipAMP(entry, page) {
+		return
+	}
+	if len(s.bodyNoise) > 0 {
+		page.NormalizeBody(s.bodyNoise)
+	}
+	if len(s.bodyNoise) > 0 || s.maxFingerprints > 0 {
+		page.Fingerprint(s.maxFingerprints)
+	}
+	contentHash := s.hash(page.DedupBody())
+	// Stored AMP versions are linked to their canonical
+	// page rather than compared to it as near-duplicates
+	if !s.validPage(page) || s.exactDuplicate(page, contentHash) || (page.AmpOf == "" && s.duplicateExists(fp, page)) {
+		return
+	}
+	page.Text = page.StripText()
+	page.ComputeStats()
+	if s.storeImages {
+		page.Images = page.FindAllImageSrcs(s.maxLinksPerPage)
+	}
+	page.Structured = page.ExtractStructuredData()
+	err = s.writeWithRetry(*page)
+	if err != nil {
+		slog.Error("spider - Giving up writing page, requeueing", "url", currentUrl, "err", err)
+		s.frontier.InsertEntry(entry, routineNum)
+		return
+	}
+	s.pageStored(page)
+	err = s.contentHashes.add(contentHash)
+	if err != nil {
+		slog.Error("spider - Error recording content hash", "err", err)
+	}
+	s.dedup.recordStored(currentUrl, contentHash)
+	if page.AmpOf == "" {
+		s.recordCanonical(page)
+		fp.InsertFingerprintsUsingWebpage(page)
+	}
+	// Nothing more will be fetched once the disk budget is spent
+	if !s.noFollow && !s.diskBudget.exceeded() {
+		anchors := s.constructProperURLs(page.FindAllAnchorHREFs(s.maxLinksPerPage), currentUrl)
+		s.applyAlternates(page, &anchors)
+		s.applyAMP(page, &anchors)
+		s.enqueueLinks(entry, anchors)
+	}
+}
+
+func (s *SearchHouseSpider) crawlFeed(routineNum int, entry FrontierEntry, feed *common.WebPage) {
+	// Enqueue the posts listed in an RSS or Atom feed,
+	// storing the feed document itself only if configured to
+	links, err := feed.FindAllFeedLinks(s.maxLinksPerPage)
+	if err != nil {
+		slog.Warn("spider - Error parsing feed", "url", feed.Url, "err", err)
+		return
+	}
+	if s.storeFeeds {
+		err = s.writeWithRetry(*feed)
+		if err != nil {
+			slog.Error("spider - Giving up writing feed, requeueing", "url", feed.Url, "err", err)
+			s.frontier.InsertEntry(entry, routineNum)
+			return
+		}
+		s.pageStored(feed)
+	}
+	if !s.noFollow {
+		s.enqueueLinks(entry, s.constructProperURLs(links, feed.Url))
+	}
+}
+
+func (s *SearchHouseSpider) enqueueLinks(entry FrontierEntry, links StringSet) {
+	// Enqueue the links found on the page of entry
+	// Sorted so the order entries are inserted in is reproducible
+	if s.externalOneHop && entry.External {
+		// External pages are one hop out, their links are two
+		return
+	}
+	for _, key := range links.Sorted() {
+		if !s.sampled(key) {
+			continue
+		}
+		if s.onlyNew {
+			isNew := !s.pageDownloaded(key) && !s.frontier.CheckURLInFrontier(key)
+			s.stats.RecordDiscovered(isNew)
+			if !isNew {
+				continue
+			}
+		}
+		if !s.hostConfigs.depthAllowed(s.getHostname(key), entry.Depth+1) {
+			continue
+		}
+		if s.pagination.beyondCap(key) {
+			s.recordSkip(key, "pagination cap")
+			continue
+		}
+		if !s.pageDownloaded(key) && (s.traps == nil || !s.traps.Trapped(key)) {
+			external := s.externalOneHop && !s.internal(s.getHostname(key))
+			s.enqueue(key, entry.Depth+1, entry.Url, external)
+		}
+	}
+}
+
+func (s *SearchHouseSpider) pageStored(page *common.WebPage) {
+	s.stats.RecordStored()
+	s.hostConfigs.recordStored(s.getHostname(page.Url))
+	if s.onPageStored == nil {
+		return
+	}
+	if s.onPageStoredAsync {
+		go s.runOnPageStored(page)
+	} else {
+		s.runOnPageStored(page)
+	}
+}
+
+func (s *SearchHouseSpider) runOnPageStored(page *common.WebPage) {
+	err := s.onPageStored(page)
+	if err != nil {
+		slog.Error("spider - OnPageStored callback failed", "url", page.Url, "err", err)
+	}
+}
+
+func (s *SearchHouseSpider) recordFailure(url string, category string, err error) {
+	slog.Warn("spider - Failed to fetch", "url", url, "category", category, "err", err)
+	s.stats.RecordFailure(category)
+	if s.failuresLog == nil {
+		return
+	}
+	line, _ := json.Marshal(failedFetch{
+		Time:     time.Now().Unix(),
+		Url:      url,
+		Category: category,
+		Error:    err.Error(),
+	})
+	s.failuresMu.Lock()
+	defer s.failuresMu.Unlock()
+	_, err = s.failuresLog.Write(append(line, '\n'))
+	if err != nil {
+		slog.Error("spider - Error recording failed fetch", "err", err)
+	}
+}
+
+func (s *SearchHouseSpider) fetch(url string, referer string) (*http.Response, []common.RedirectHop, time.Duration, error) {
+	// GET the URL, collecting every redirect hop followed on the way.
+	// The latency excludes waiting for the rate limits and host slots
+	redirects := make([]common.RedirectHop, 0)
+	ctx := context.WithValue(context.Background(), redirectChainKey{}, &redirects)
+	var timings fetchTimings
+	if s.traceTimings {
+		ctx = withFetchTimings(ctx, &timings)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	s.applyUserAgent(req)
+	s.applyHostHeader(req)
+	if s.acceptHeader != "" {
+		req.Header.Set("Accept", s.acceptHeader)
+	}
+	if s.acceptEncoding != "" {
+		// Setting it stops the transport from decompressing, readBody does
+		req.Header.Set("Accept-Encoding", s.acceptEncoding)
+	}
+	if s.sendReferer && referer != "" {
+		req.Header.Set("Referer", referer)
+	}
+	err = s.waitForRateLimits(ctx, req.URL.Host)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	release, err := s.acquireHostSlot(ctx, req.URL.Host)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	start := time.Now()
+	resp, err := s.client.Do(req)
+	latency := time.Since(start)
+	if err != nil {
+		release()
+	} else {
+		// The request is in flight until its body is closed
+		resp.Body = &releasingBody{ReadCloser: resp.Body, release: sync.OnceFunc(release)}
+	}
+	if s.traceTimings && err == nil {
+		timings.log(url)
+	}
+	return resp, redirects, latency, err
 }
 
-func NewSpider(numRoutines int, workingDirectory string, seed []string, maxLinks int) *SearchHouseSpider {
-	ioMu := new(sync.Mutex)
-	wpCache, _ := lru.New[string, bool](1000)
-	cs := SearchHouseSpider{
-		numRoutines:      numRoutines,
-		workingDirectory: workingDirectory,
-		maxLinksPerPage:  maxLinks,
-		ioMu:             ioMu,
-		wordpressSites:   wpCache,
+func (s *SearchHouseSpider) userAgentFor(hostname string) string {
+	if userAgent, exists := s.hostUserAgents[strings.ToLower(hostname)]; exists {
+		return userAgent
 	}
-	cs.frontier.Init()
-	cs.setSeed(seed)
-	return &cs
+	return s.userAgent
 }
 
-func (s *SearchHouseSpider) CrawlConcurrently() {
-	wg := new(sync.WaitGroup)
-	wg.Add(s.numRoutines)
-	for i := 0; i < s.numRoutines; i++ {
-		go s.Crawl(i, wg)
+func (s *SearchHouseSpider) applyUserAgent(req *http.Request) {
+	// Crawl requests and probes identify themselves the same
+	// way, sites gating on the User-Agent would fail the probes
+	// otherwise. Go's default is left when none is configured
+	if userAgent := s.userAgentFor(req.URL.Hostname()); userAgent != "" {
+		req.Header.Set("User-Agent", userAgent)
 	}
-	wg.Wait()
 }
 
-func (s *SearchHouseSpider) Crawl(routineNum int, wg *sync.WaitGroup) {
-	defer wg.Done()
-	fp := common.NewFingerprints(3, 10000)
-	for {
-		currentUrl := s.frontier.PopURL(routineNum)
-		if currentUrl == "" {
-			time.Sleep(time.Second)
-			continue
+func (s *SearchHouseSpider) applyHostHeader(req *http.Request) {
+	// Every request to a host, crawl or probe, goes out
+	// with the same Host header override, if it has one
+	if hostHeader, exists := s.hostHeaders[strings.ToLower(req.URL.Hostname())]; exists {
+		req.Host = hostHeader
+	} else if s.hostHeader != "" {
+		req.Host = s.hostHeader
+	}
+}
+
+func (s *SearchHouseSpider) dialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+	if override, exists := s.hostOverrides[host]; exists {
+		address = net.JoinHostPort(override, port)
+	}
+	return s.dialer.DialContext(ctx, network, address)
+}
+
+func (s *SearchHouseSpider) waitForRateLimits(ctx context.Context, hostname string) error {
+	if s.globalLimiter != nil {
+		err := s.globalLimiter.Wait(ctx)
+		if err != nil {
+			return err
 		}
-		if !s.urlValid(currentUrl) {
-			continue
+	}
+	if s.perHostRPS <= 0 {
+		return nil
+	}
+	s.hostLimitersMu.Lock()
+	limiter, exists := s.hostLimiters[hostname]
+	if !exists {
+		limiter = rate.NewLimiter(rate.Limit(s.perHostRPS), 1)
+		s.hostLimiters[hostname] = limiter
+	}
+	s.hostLimitersMu.Unlock()
+	return limiter.Wait(ctx)
+}
+
+func (s *SearchHouseSpider) acquireHostSlot(ctx context.Context, hostname string) (func(), error) {
+	// Wait for one of the host's maxPerHost slots, returning
+	// the function giving it back
+	if s.maxPerHost <= 0 {
+		return func() {}, nil
+	}
+	s.hostSlotsMu.Lock()
+	slots, exists := s.hostSlots[hostname]
+	if !exists {
+		slots = make(chan struct{}, s.maxPerHost)
+		s.hostSlots[hostname] = slots
+	}
+	s.hostSlotsMu.Unlock()
+	select {
+	case slots <- struct{}{}:
+		return func() { <-slots }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// releasingBody gives back a host slot when the body is closed
+
+type releasingBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (rb *releasingBody) Close() error {
+	err := rb.ReadCloser.Close()
+	rb.release()
+	return err
+}
+
+func guardPrivateAddress(network, address string, c syscall.RawConn) error {
+	// Called after DNS resolution with the address about to
+	// be dialed, so rebinding a hostname can't bypass it
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsUnspecified() {
+		return fmt.Errorf("%w: %s", errPrivateAddress, host)
+	}
+	return nil
+}
+
+func (s *SearchHouseSpider) honorRetryAfter(resp *http.Response) bool {
+	// Back off from a host answering 429 or 503 with a Retry-After,
+	// returning whether the URL should be retried afterwards
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return false
+	}
+	delay, ok := parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	if !ok {
+		return false
+	}
+	hostname := resp.Request.URL.Hostname()
+	s.hostConfigs.setRetryAfter(hostname, s.capCrawlDelay(hostname, delay, "Retry-After"))
+	return true
+}
+
+func (s *SearchHouseSpider) retry(entry FrontierEntry, routineNum int) {
+	// Requeue a throttled URL unless it used up its retries
+	if entry.Retries >= s.maxRetries {
+		slog.Warn("spider - Giving up on a URL throttled too many times", "url", entry.Url, "retries", entry.Retries)
+		return
+	}
+	entry.Retries++
+	s.frontier.InsertEntry(entry, routineNum)
+}
+
+func (s *SearchHouseSpider) capCrawlDelay(hostname string, delay time.Duration, source string) time.Duration {
+	// Limit a delay requested by a host to maxCrawlDelay
+	if s.maxCrawlDelay > 0 && delay > s.maxCrawlDelay {
+		slog.Info("spider - Capping the delay a host asked for", "host", hostname, "delay", delay, "source", source, "cap", s.maxCrawlDelay)
+		return s.maxCrawlDelay
+	}
+	return delay
+}
+
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	// Retry-After is either a number of seconds or an HTTP date
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(strings.TrimSpace(value)); err == nil {
+		return time.Duration(max(seconds, 0)) * time.Second, true
+	}
+	date, err := http.ParseTime(value)
+	if err != nil {
+		return 0, false
+	}
+	return max(date.Sub(now), 0), true
+}
+
+func (s *SearchHouseSpider) followMetaRefresh(entry FrontierEntry, target string) bool {
+	// Treat a meta refresh like a redirect, enqueueing its target
+	// at the stub's depth and marking the stub as downloaded
+	stubUrl, err := url.Parse(entry.Url)
+	if err != nil {
+		return false
+	}
+	targetUrl, err := stubUrl.Parse(target)
+	if err != nil || s.canonicalize(targetUrl.String()) == s.canonicalize(entry.Url) {
+		// Pages refreshing themselves are stored as usual
+		return false
+	}
+	links := s.constructProperURLs([]string{targetUrl.String()}, entry.Url)
+	if len(links.m) == 0 {
+		return false
+	}
+	slog.Info("spider - Following meta refresh", "url", entry.Url, "target", targetUrl)
+	s.refreshStubsMu.Lock()
+	s.refreshStubs.Add(s.canonicalize(entry.Url))
+	s.refreshStubsMu.Unlock()
+	for _, link := range links.Sorted() {
+		if !s.pageDownloaded(link) {
+			s.enqueue(link, entry.Depth, entry.Url, entry.External)
 		}
-		if !s.pageDownloaded(currentUrl) {
-			resp, err := http.Get(currentUrl)
-			if err == nil && resp.Status == "200 OK" {
-				body, err := io.ReadAll(resp.Body)
-				if err == nil {
-					page := common.NewWebPage(time.Now().Unix(), currentUrl, resp.Status, string(body))
-					resp.Body.Close()
-					if !s.validPage(page) || s.duplicateExists(fp, page) {
-						continue
-					}
-					fp.InsertFingerprintsUsingWebpage(page)
-					s.writeToDisk(*page)
-					anchors := s.constructProperURLs(page.FindAllAnchorHREFs(s.maxLinksPerPage), currentUrl)
-					for key := range anchors.m {
-						if !s.pageDownloaded(key) {
-							s.frontier.InsertPage(key, s.calcWebsiteToRoutineNum(key))
-						}
-					}
-				}
-			}
-			time.Sleep(time.Second * 5)
+	}
+	return true
+}
+
+func (s *SearchHouseSpider) readBody(resp *http.Response) ([]byte, error) {
+	// Read, decompress and close the body, rejecting it before
+	// reading when its Content-Length is over the limit. The
+	// header may be missing (chunked responses) or lie, and a
+	// small compressed body can inflate to any size, so the read
+	// itself is always limited. Reading one byte past the limit
+	// tells a truncated stream apart from one ending right at it
+	defer resp.Body.Close()
+	if s.maxBodyBytes > 0 && resp.ContentLength > s.maxBodyBytes {
+		return nil, fmt.Errorf("%w: Content-Length %d", errBodyTooLarge, resp.ContentLength)
+	}
+	received := &countingReader{r: resp.Body}
+	reader, err := decodeBody(resp.Header.Get("Content-Encoding"), received)
+	if err != nil {
+		return nil, err
+	}
+	if s.maxBodyBytes > 0 {
+		reader = io.LimitReader(reader, s.maxBodyBytes+1)
+	}
+	body, err := io.ReadAll(reader)
+	s.stats.RecordBytes(received.n, int64(len(body)))
+	if err != nil {
+		return nil, err
+	}
+	if s.maxBodyBytes > 0 && int64(len(body)) > s.maxBodyBytes {
+		if resp.ContentLength < 0 {
+			return nil, fmt.Errorf("%w: over %d bytes streamed without Content-Length", errBodyTooLarge, s.maxBodyBytes)
 		}
+		return nil, fmt.Errorf("%w: over %d bytes", errBodyTooLarge, s.maxBodyBytes)
+	}
+	return body, nil
+}
+
+func (s *SearchHouseSpider) checkRedirect(req *http.Request, via []*http.Request) error {
+	if len(via) > s.maxRedirects {
+		return fmt.Errorf("stopped after %d redirects", s.maxRedirects)
 	}
+	// The client only keeps a custom Host across relative redirects,
+	// and the User-Agent of the host redirected from
+	s.applyUserAgent(req)
+	s.applyHostHeader(req)
+	permanent := req.Response.StatusCode == http.StatusMovedPermanently || req.Response.StatusCode == http.StatusPermanentRedirect
+	if s.hostForms != nil && permanent {
+		s.hostForms.observeRedirect(via[len(via)-1].URL, req.URL)
+	}
+	if redirects, ok := req.Context().Value(redirectChainKey{}).(*[]common.RedirectHop); ok {
+		// req.URL is the Location header already resolved against
+		// the previous request, so relative Locations are absolute
+		*redirects = append(*redirects, common.RedirectHop{
+			Status:   req.Response.StatusCode,
+			Url:      via[len(via)-1].URL.String(),
+			Location: req.URL.String(),
+		})
+	}
+	return nil
 }
 
-func (s *SearchHouseSpider) writeToDisk(w common.WebPage) {
-	fileName := filepath.Join(s.workingDirectory, strconv.FormatUint(s.hash(w.Url), 10)+".json")
+func (s *SearchHouseSpider) writeToDisk(w common.WebPage) error {
+	if s.indexOnly != nil {
+		// Nothing is written in index-only mode, the
+		// page is handed to the sink and then dropped
+		return s.indexOnly.add(&w, s.canonicalize(w.Url))
+	}
+	fileName := s.pageFileName(w.Url)
+	if s.compress {
+		fileName = s.compressedPageFileName(w.Url)
+	}
 	s.ioMu.Lock()
 	defer s.ioMu.Unlock()
-	f, err := os.Create(fileName)
+	// Write to a temporary file and rename it into place so a
+	// crash mid-write never leaves a truncated page behind
+	f, err := os.CreateTemp(s.workingDirectory, ".tmp-*")
 	if err != nil {
-		log.Fatalln(err)
+		return err
+	}
+	if s.compress {
+		gw := gzip.NewWriter(f)
+		_, err = gw.Write(w.Serialize())
+		if err == nil {
+			err = gw.Close()
+		}
+	} else {
+		_, err = f.Write(w.Serialize())
+	}
+	if err == nil {
+		// Flush to stable storage first, otherwise the rename can
+		// be persisted before the data it points to
+		err = f.Sync()
+	}
+	var info os.FileInfo
+	if err == nil {
+		// The size on disk, after compression
+		info, err = f.Stat()
 	}
-	defer f.Close()
-	_, err = f.Write(w.Serialize())
 	if err != nil {
-		log.Fatalln(err)
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	err = f.Close()
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	err = os.Rename(f.Name(), fileName)
+	if err != nil {
+		return err
+	}
+	if s.downloaded != nil {
+		s.downloaded.Add(s.hash(s.canonicalize(w.Url)))
+	}
+	s.diskBudget.add(info.Size())
+	return nil
+}
+
+func (s *SearchHouseSpider) removeStaleTempFiles() {
+	// Temporary files left over from a crash mid-write
+	// were never renamed into place and can be discarded
+	tempFiles, err := filepath.Glob(filepath.Join(s.workingDirectory, ".tmp-*"))
+	if err != nil {
+		slog.Error("spider - Error finding stale temporary files", "err", err)
+		return
+	}
+	for _, tempFile := range tempFiles {
+		err = os.Remove(tempFile)
+		if err != nil {
+			slog.Error("spider - Error removing stale temporary file", "err", err)
+		}
+	}
+}
+
+func (s *SearchHouseSpider) VerifyStoredPages() error {
+	// Remove every stored page that doesn't deserialize into a
+	// WebPage (e.g. half-written before a crash) so it's re-fetched.
+	// This reads the whole corpus so it should only be run on resume
+	entries, err := os.ReadDir(s.workingDirectory)
+	if err != nil {
+		return err
+	}
+	removed := 0
+	for _, entry := range entries {
+		if entry.IsDir() || !common.IsStoredPageName(entry.Name()) {
+			continue
+		}
+		fileName := filepath.Join(s.workingDirectory, entry.Name())
+		_, err = common.ReadStoredPage(fileName)
+		if err != nil {
+			slog.Warn("spider - Stored page is corrupt, removing", "file", fileName, "err", err)
+			err = os.Remove(fileName)
+			if err != nil {
+				return err
+			}
+			removed++
+		}
+	}
+	slog.Info("spider - Verified stored pages", "removed", removed)
+	return nil
+}
+
+func (s *SearchHouseSpider) RenameStoredPages() error {
+	// Rename the pages stored by runs that named them after the
+	// hash of another spelling of their URL, before URLs were
+	// canonicalized the current way, so they count as downloaded.
+	// When two files hold the same page the newest crawl is kept.
+	// This reads the whole corpus so it should only be run once
+	entries, err := os.ReadDir(s.workingDirectory)
+	if err != nil {
+		return err
+	}
+	renamed, removed := 0, 0
+	for _, entry := range entries {
+		if entry.IsDir() || !common.IsStoredPageName(entry.Name()) {
+			continue
+		}
+		fileName := filepath.Join(s.workingDirectory, entry.Name())
+		page, err := common.ReadStoredPage(fileName)
+		if err != nil {
+			slog.Warn("spider - Error reading stored page, leaving it", "file", fileName, "err", err)
+			continue
+		}
+		target := s.pageFileName(page.Url)
+		if strings.HasSuffix(entry.Name(), common.CompressedPageExt) {
+			target = s.compressedPageFileName(page.Url)
+		}
+		if target == fileName {
+			continue
+		}
+		if existing, err := common.ReadStoredPage(target); err == nil && existing.Time >= page.Time {
+			err = os.Remove(fileName)
+			if err != nil {
+				return err
+			}
+			removed++
+			continue
+		}
+		err = os.Rename(fileName, target)
+		if err != nil {
+			return err
+		}
+		renamed++
+	}
+	slog.Info("spider - Renamed stored pages to their canonical URL", "renamed", renamed, "removed", removed)
+	return nil
+}
+
+func (s *SearchHouseSpider) writeWithRetry(w common.WebPage) error {
+	// Retry transient write failures a couple of times,
+	// backing off for longer when the disk is full so
+	// space has a chance to be freed before giving up
+	var err error
+	for attempt := 0; attempt <= writeRetries; attempt++ {
+		err = s.writeToDisk(w)
+		if err == nil {
+			return nil
+		}
+		slog.Warn("spider - Failed to write page to disk", "url", w.Url, "attempt", attempt+1, "err", err)
+		if attempt == writeRetries {
+			break
+		}
+		if errors.Is(err, syscall.ENOSPC) {
+			sleepBeforeRetry(diskFullBackoff)
+		} else {
+			sleepBeforeRetry(time.Second)
+		}
 	}
+	return err
 }
 
 func (s *SearchHouseSpider) fileExists(path string) (bool, error) {
@@ -114,33 +1658,194 @@ func (s *SearchHouseSpider) fileExists(path string) (bool, error) {
 	}
 }
 
+func (s *SearchHouseSpider) pageFileName(url string) string {
+	// Pages are stored under the hash of their canonical URL so
+	// different spellings of the same URL share a single file
+	return filepath.Join(s.workingDirectory, strconv.FormatUint(s.hash(s.canonicalize(url)), 10)+common.PageExt)
+}
+
+func (s *SearchHouseSpider) compressedPageFileName(url string) string {
+	return filepath.Join(s.workingDirectory, strconv.FormatUint(s.hash(s.canonicalize(url)), 10)+common.CompressedPageExt)
+}
+
 func (s *SearchHouseSpider) pageDownloaded(url string) bool {
-	fileName := filepath.Join(s.workingDirectory, strconv.FormatUint(s.hash(url), 10)+".json")
+	// A page counts as downloaded whether it was stored
+	// compressed or not, so -compress can be toggled between runs.
+	// Meta refresh stubs count too, although they aren't stored
+	s.refreshStubsMu.Lock()
+	stub := s.refreshStubs.Contains(s.canonicalize(url))
+	s.refreshStubsMu.Unlock()
+	if stub {
+		return true
+	}
+	if s.indexOnly != nil {
+		return s.indexOnly.contains(s.canonicalize(url))
+	}
+	if s.downloaded != nil && !s.downloaded.MayContain(s.hash(s.canonicalize(url))) {
+		return false
+	}
 	s.ioMu.Lock()
 	defer s.ioMu.Unlock()
-	exists, err := s.fileExists(fileName)
-	if err != nil {
-		log.Fatalln(err)
+	for _, fileName := range []string{s.pageFileName(url), s.compressedPageFileName(url)} {
+		exists, err := s.fileExists(fileName)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if exists {
+			return true
+		}
 	}
-	return exists
+	return false
 }
 
 func (s *SearchHouseSpider) urlValid(url string) bool {
-	urlRe := regexp.MustCompile(`^(https://[-a-zA-Z0-9@:%._+~#=]{1,256}\.[a-zA-Z0-9()]{1,6}[-a-zA-Z0-9()@:_+~?=/]*)$`)
+	// URLs of hosts whose WordPress probe was throttled are
+	// valid for now, Crawl probes them again when popped
+	reason := s.invalidURLReason(url)
+	if reason == wordPressUnknown {
+		return true
+	}
+	if reason != "" {
+		s.recordSkip(url, reason)
+	}
+	return reason == ""
+}
+
+func (s *SearchHouseSpider) invalidURLReason(url string) string {
+	// Why url shouldn't be crawled, or "" if it should
+	// URLs are checked canonicalized, where non-ASCII characters
+	// are percent-encoded, so % is allowed after the host
+	urlRe := regexp.MustCompile(`^(https://[-a-zA-Z0-9@:%._+~#=]{1,256}\.[a-zA-Z0-9()]{1,6}[-a-zA-Z0-9()@:%_+~?&=/.]*)$`)
 	extRe := regexp.MustCompile(`.*\.(?:css|js|bmp|gif|jpe?g|ico|png|tiff?|mid|mp2|mp3|mp4|ppsx|wav|avi|mov|mpeg|ram|m4v|mkv|ogg|ogv|pdf|odc|sas|ps|eps|tex|ppt|pptx|doc|docx|xls|xlsx|names|data|dat|exe|bz2|tar|msi|bin|7z|psd|dmg|iso|epub|dll|cnf|tgz|sha1|ss|scm|py|rkt|r|c|thmx|mso|arff|rtf|jar|csv|java|txt|rm|smil|wmv|swf|wma|zip|rar|gz)$`)
-	if urlRe.MatchString(url) && !extRe.MatchString(strings.ToLower(url)) {
-		return s.isWordPressWebsite(s.getHostname(url))
+	if s.maxURLLength > 0 && len(url) > s.maxURLLength {
+		slog.Info("spider - Rejected overlong URL", "length", len(url), "prefix", url[:min(len(url), 100)])
+		return "URL too long"
+	}
+	if !urlRe.MatchString(url) {
+		return "malformed URL"
+	}
+	// Only the path, ?file=report.pdf says nothing about the page
+	if extRe.MatchString(strings.ToLower(urlPath(url))) {
+		return "unwanted extension"
+	}
+	if s.excluded(url) {
+		return "excluded pattern"
+	}
+	if s.hasSkippedQueryParam(url) {
+		return "skipped query parameter"
+	}
+	hostname := s.getHostname(url)
+	if !s.inScope(hostname) {
+		return "out of scope"
+	}
+	if s.deadHosts.isDead(hostname) {
+		return "dead host"
+	}
+	if !s.underPathPrefix(hostname, url) {
+		return "outside path prefixes"
+	}
+	if s.requireWordPress {
+		if isWp, known := s.isWordPressWebsite(hostname); !known {
+			return wordPressUnknown
+		} else if !isWp {
+			return "not WordPress"
+		}
+	}
+	return ""
+}
+
+func urlPath(rawUrl string) string {
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return rawUrl
+	}
+	return parsedUrl.Path
+}
+
+func (s *SearchHouseSpider) excluded(url string) bool {
+	if s.followFeeds && feedRe.MatchString(url) {
+		// Feeds are excluded by default but needed to follow them
+		return false
+	}
+	for _, re := range s.excludePatterns {
+		if re.MatchString(url) {
+			slog.Info("spider - Rejected URL matching exclude pattern", "url", url, "pattern", re.String())
+			return true
+		}
+	}
+	return false
+}
+
+func (s *SearchHouseSpider) hasSkippedQueryParam(rawUrl string) bool {
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return false
+	}
+	for param := range parsedUrl.Query() {
+		if s.skipQueryParams.Contains(param) {
+			slog.Info("spider - Rejected URL with skipped query parameter", "url", rawUrl, "param", param)
+			return true
+		}
+	}
+	return false
+}
+
+func (s *SearchHouseSpider) inScope(hostname string) bool {
+	if s.externalOneHop {
+		// External hosts are in scope, enqueueLinks keeps
+		// the crawl from going further than one hop into them
+		return true
+	}
+	if s.includeSubdomains {
+		return s.seedDomains.Contains(s.registeredDomain(hostname))
+	}
+	return !s.sameHostAsSeed || s.seedHosts.Contains(hostname)
+}
+
+func (s *SearchHouseSpider) underPathPrefix(hostname string, rawUrl string) bool {
+	if !s.seedHosts.Contains(hostname) {
+		return true
+	}
+	prefixes := s.hostConfigs.pathPrefixes(hostname, s.pathPrefixes)
+	if len(prefixes) == 0 {
+		return true
+	}
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return false
+	}
+	for _, prefix := range prefixes {
+		// Canonical URLs have no trailing slash, so /docs is under /docs/
+		if strings.HasPrefix(parsedUrl.Path, prefix) || parsedUrl.Path == strings.TrimSuffix(prefix, "/") {
+			return true
+		}
 	}
 	return false
 }
 
+func (s *SearchHouseSpider) internal(hostname string) bool {
+	// Whether a host belongs to one of the seed sites
+	if s.includeSubdomains {
+		return s.seedDomains.Contains(s.registeredDomain(hostname))
+	}
+	return s.seedHosts.Contains(hostname)
+}
+
+func (s *SearchHouseSpider) registeredDomain(hostname string) string {
+	domain, err := publicsuffix.EffectiveTLDPlusOne(hostname)
+	if err != nil {
+		return hostname
+	}
+	return domain
+}
+
 func (s *SearchHouseSpider) findHostName(url string) string {
-	domainRe := regexp.MustCompile(`https://[^\s:/@]+\.[^\s:/@]+`)
 	substr := domainRe.FindAllStringSubmatch(url, -1)
 	if len(substr) == 0 || len(substr[0]) == 0 {
 		return ""
 	}
-	return substr[0][0]
+	// The match never includes a port, so only the dot can follow the host
+	return strings.TrimSuffix(substr[0][0], ".")
 }
 
 func (s *SearchHouseSpider) constructProperURLs(urls []string, root string) StringSet {
@@ -156,14 +1861,21 @@ func (s *SearchHouseSpider) constructProperURLs(urls []string, root string) Stri
 		} else {
 			parsedURL = strings.TrimSuffix(urlStr, "/")
 		}
-		if s.urlValid(parsedURL) {
-			properURLs.Add(parsedURL)
+		// Validated as it will be popped from the frontier
+		canonical := s.canonicalize(parsedURL)
+		if s.urlValid(canonical) {
+			properURLs.Add(canonical)
 		}
 	}
 	return properURLs
 }
 
 func (s *SearchHouseSpider) hash(str string) uint64 {
+	return s.hashFunc(str)
+}
+
+func FNVHash(str string) uint64 {
+	// The default HashFunc, 64-bit FNV-1a
 	h := fnv.New64a()
 	_, err := h.Write([]byte(str))
 	if err != nil {
@@ -186,62 +1898,258 @@ func (s *SearchHouseSpider) abs(val int) int {
 }
 
 func (s *SearchHouseSpider) setSeed(urls []string) {
+	// Seeds given a priority raise the priority of every URL
+	// of their host, so the site is crawled ahead of the others.
+	// The highest priority wins when a host has several seeds
+	s.hostPriorities = make(map[string]float64)
+	for _, urlStr := range urls {
+		if priority, ok := s.seedPriorities[urlStr]; ok {
+			if math.IsInf(priority, 0) || math.IsNaN(priority) {
+				slog.Warn("spider - Ignoring non-finite seed priority", "seed", urlStr, "priority", priority)
+				continue
+			}
+			hostname := s.getHostname(s.canonicalize(urlStr))
+			if current, seen := s.hostPriorities[hostname]; !seen || priority > current {
+				s.hostPriorities[hostname] = priority
+			}
+		}
+	}
 	for _, urlStr := range urls {
+		s.seedHosts.Add(s.getHostname(urlStr))
+		s.seedDomains.Add(s.registeredDomain(s.getHostname(urlStr)))
 		if !s.pageDownloaded(urlStr) {
-			s.frontier.InsertPage(urlStr, 0)
+			s.enqueue(urlStr, 0, "", false)
 		}
 	}
 }
 
-func (s *SearchHouseSpider) duplicateExists(fp *common.Fingerprints, wp *common.WebPage) bool {
-	fpGlobalSet := fp.GetFingerprintsAsSet()
-	fpWebpageSet := wp.Fingerprints.GetFingerprintsAsSet()
-	fp.Mu.Lock()
-	wp.Fingerprints.Mu.Lock()
-	defer wp.Fingerprints.Mu.Unlock()
-	defer fp.Mu.Unlock()
-	for hash := range fpWebpageSet {
-		if pages, exists := fpGlobalSet[hash]; exists {
-			for page := range pages {
-				if page.Url != wp.Url && wp.Similarity(page) > 0.9 {
-					log.Printf("spider - %s has a %f match to %s\n", page.Url, wp.Similarity(page), wp.Url)
-					return true
-				}
+func (s *SearchHouseSpider) anyCrawlable(seeds []string) bool {
+	// Whether a seed passes urlValid, including the WordPress
+	// check, otherwise the crawl would idle on an empty frontier
+	crawlable := false
+	for _, seed := range seeds {
+		if reason := s.invalidURLReason(s.canonicalize(seed)); reason != "" && reason != wordPressUnknown {
+			slog.Warn("spider - Seed can't be crawled", "seed", seed, "reason", reason)
+		} else {
+			crawlable = true
+		}
+	}
+	return crawlable
+}
+
+func (s *SearchHouseSpider) sampled(url string) bool {
+	if s.sampleRate >= 1 {
+		return true
+	}
+	return float64(s.hash(url)%10000) < s.sampleRate*10000
+}
+
+func (s *SearchHouseSpider) enqueue(url string, depth int, referer string, external bool) {
+	url = s.canonicalize(url)
+	priority := s.scorer.Score(url, depth) + s.hostPriorities[s.getHostname(url)]
+	entry := FrontierEntry{Url: url, Depth: depth, Priority: priority, Referer: referer, External: external}
+	s.frontier.InsertEntry(entry, s.calcWebsiteToRoutineNum(url))
+	s.discovered.emit(url)
+}
+
+func (s *SearchHouseSpider) reachableSeeds(urls []string) []string {
+	client := &http.Client{Transport: s.transport, Timeout: s.probeTimeout}
+	reachable := make(map[string]bool)
+	seeds := make([]string, 0, len(urls))
+	for _, urlStr := range urls {
+		hostname := s.getHostname(urlStr)
+		if _, probed := reachable[hostname]; !probed {
+			resp, err := s.probeHost(client, hostname)
+			if err != nil {
+				slog.Warn("spider - Seed host is unreachable, dropping its seeds", "host", hostname, "err", err)
+			} else {
+				resp.Body.Close()
 			}
+			reachable[hostname] = err == nil
+		}
+		if reachable[hostname] {
+			seeds = append(seeds, urlStr)
 		}
 	}
-	return false
+	return seeds
+}
+
+func (s *SearchHouseSpider) probeHost(client *http.Client, hostname string) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodHead, "https://"+hostname+"/", nil)
+	if err != nil {
+		return nil, err
+	}
+	s.applyUserAgent(req)
+	s.applyHostHeader(req)
+	release, err := s.acquireHostSlot(req.Context(), req.URL.Host)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return client.Do(req)
+}
+
+func (s *SearchHouseSpider) exactDuplicate(wp *common.WebPage, contentHash uint64) bool {
+	// Whether a byte-identical page was already stored
+	if !s.contentHashes.contains(contentHash) {
+		return false
+	}
+	slog.Info("spider - Page is identical to a stored page", "url", wp.Url)
+	s.recordSkip(wp.Url, "exact duplicate")
+	s.dedup.recordExact(wp.Url, contentHash)
+	return true
+}
+
+func (s *SearchHouseSpider) duplicateExists(detector common.DuplicateDetector, wp *common.WebPage) bool {
+	// Pages declaring the same rel=canonical are definitely duplicates,
+	// only pages with distinct or no canonicals are compared by content
+	if canonical := wp.FindCanonical(); canonical != "" {
+		canonical = common.Canonicalize(canonical)
+		s.canonicalsMu.Lock()
+		duplicateUrl, exists := s.canonicals[canonical]
+		s.canonicalsMu.Unlock()
+		if exists && duplicateUrl != wp.Url {
+			slog.Info("spider - Page shares its canonical URL with a stored page", "url", wp.Url, "canonical", canonical, "duplicate", duplicateUrl)
+			s.recordSkip(wp.Url, "duplicate canonical of "+duplicateUrl)
+			s.dedup.recordDuplicate(wp.Url, duplicateUrl, true)
+			return true
+		}
+	}
+	duplicateUrl, similarity := detector.FindDuplicate(wp, s.duplicateThreshold)
+	if duplicateUrl == "" {
+		return false
+	}
+	slog.Info("spider - Page is a near-duplicate of a stored page", "url", wp.Url, "duplicate", duplicateUrl, "similarity", similarity)
+	s.recordSkip(wp.Url, "near-duplicate of "+duplicateUrl)
+	s.dedup.recordDuplicate(wp.Url, duplicateUrl, false)
+	return true
+}
+
+func (s *SearchHouseSpider) recordCanonical(wp *common.WebPage) {
+	// Claim the page's rel=canonical once it's stored, so pages
+	// rejected after the duplicate check don't claim it. The
+	// first page stored keeps it if several race for it
+	canonical := wp.FindCanonical()
+	if canonical == "" {
+		return
+	}
+	canonical = common.Canonicalize(canonical)
+	s.canonicalsMu.Lock()
+	defer s.canonicalsMu.Unlock()
+	if _, exists := s.canonicals[canonical]; !exists {
+		s.canonicals[canonical] = wp.Url
+	}
+}
+
+func (s *SearchHouseSpider) newDuplicateDetector() common.DuplicateDetector {
+	if s.duplicateAlgo == SimHashAlgo {
+		return common.NewSimHashes(3, s.duplicateWindow)
+	}
+	fp := common.NewFingerprints(3, s.duplicateWindow)
+	fp.SetMaxHashes(s.maxFingerprints)
+	return fp
 }
 
 func (s *SearchHouseSpider) validPage(wp *common.WebPage) bool {
+	accepted, reason := s.accept(wp)
+	if !accepted {
+		slog.Info("spider - Rejected page", "url", wp.Url, "reason", reason)
+		s.recordSkip(wp.Url, "rejected page: "+reason)
+	}
+	return accepted
+}
+
+func AcceptHTML(wp *common.WebPage) (bool, string) {
+	// The default AcceptFunc, accepting pages starting with an HTML DOCTYPE
 	trimmedBody := strings.TrimLeftFunc(wp.Body, unicode.IsSpace)
-	return strings.HasPrefix(trimmedBody, "<!DOCTYPE html") || strings.HasPrefix(trimmedBody, "<!doctype html")
+	if strings.HasPrefix(trimmedBody, "<!DOCTYPE html") || strings.HasPrefix(trimmedBody, "<!doctype html") {
+		return true, ""
+	}
+	return false, "no HTML DOCTYPE"
 }
 
-func (s *SearchHouseSpider) isWordPressWebsite(str string) bool {
+func (s *SearchHouseSpider) isWordPressWebsite(str string) (bool, bool) {
+	// Whether the host is WordPress and whether that's known,
+	// it isn't when a probe was throttled without any other
+	// probe finding WordPress, which isn't cached so the host
+	// is probed again later
 	if isWp, exists := s.wordpressSites.Get(str); exists {
-		return isWp
+		return isWp, true
 	}
-	isWp := false
-	resp, err := http.Get("https://" + str + "/wp-admin")
-	if err == nil && resp != nil {
-		content, err := io.ReadAll(resp.Body)
-		if err == nil {
-			if resp.StatusCode == 403 || (resp.StatusCode == 200 && strings.Contains(strings.ToLower(string(content)), "wordpress")) {
-				isWp = true
-			}
+	s.wpRetryMu.Lock()
+	retryAt := s.wpRetryAt[str]
+	s.wpRetryMu.Unlock()
+	if time.Now().Before(retryAt) {
+		return false, false
+	}
+	// Probe each path in order until one looks like WordPress
+	isWp, known := false, true
+	for _, path := range s.wpProbePaths {
+		var throttled bool
+		isWp, throttled = s.probeWordPress("https://" + str + path)
+		if isWp {
+			known = true
+			break
 		}
-		resp.Body.Close()
+		if throttled {
+			known = false
+		}
+	}
+	if !known {
+		// Hold off probing until the host's Retry-After is
+		// over, peeking at it since Crawl waits it out too
+		delay := max(s.crawlDelay, s.hostConfigs.pendingRetryAfter((&url.URL{Host: str}).Hostname()))
+		slog.Info("spider - WordPress probe throttled, retrying later", "host", str, "delay", delay)
+		s.wpRetryMu.Lock()
+		s.wpRetryAt[str] = time.Now().Add(delay)
+		s.wpRetryMu.Unlock()
+		return false, false
 	}
 	s.wordpressSites.Add(str, isWp)
-	return isWp
+	return isWp, true
+}
+
+func (s *SearchHouseSpider) probeWordPress(url string) (bool, bool) {
+	// A forbidden admin path or a page mentioning WordPress (or
+	// the wp/v2 namespace of the REST API index) means WordPress.
+	// A 429 or 503 says nothing either way and is reported as
+	// throttled, honoring its Retry-After like any other fetch
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return false, false
+	}
+	s.applyUserAgent(req)
+	s.applyHostHeader(req)
+	release, err := s.acquireHostSlot(req.Context(), req.URL.Host)
+	if err != nil {
+		return false, false
+	}
+	defer release()
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return false, false
+	}
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return false, false
+	}
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		s.honorRetryAfter(resp)
+		return false, true
+	}
+	if resp.StatusCode == 403 {
+		return true, false
+	}
+	body := strings.ToLower(string(content))
+	return resp.StatusCode == 200 && (strings.Contains(body, "wordpress") || strings.Contains(body, "wp/v2") || strings.Contains(body, `wp\/v2`)), false
 }
 
 func (s *SearchHouseSpider) getHostname(u string) string {
 	parsedUrl, err := url.Parse(u)
 	if err != nil {
-		log.Println("spider - Error parsing URL:", err)
+		slog.Warn("spider - Error parsing URL", "err", err)
 		return ""
 	}
-	return parsedUrl.Host
+	return common.TrimHostDot(parsedUrl.Host)
 }
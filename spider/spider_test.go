@@ -0,0 +1,239 @@
+package spider
+
+import (
+	"encoding/json"
+	"math"
+	"os"
+	"path/filepath"
+	"searchHouse/common"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestSpider(t testing.TB, configure func(config *Config)) *SearchHouseSpider {
+	// A spider crawling into a temporary directory, without the
+	// WordPress probe so no test depends on the network. The
+	// frontier is created in the working directory, so the test
+	// runs in the temporary directory too
+	t.Helper()
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+	config := DefaultConfig()
+	config.PageDir = dir
+	config.RequireWordPress = false
+	if configure != nil {
+		configure(&config)
+	}
+	s, err := NewSpider(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestConstructProperURLsKeepsQueries(t *testing.T) {
+	s := newTestSpider(t, nil)
+	links := s.constructProperURLs([]string{"/p?redirect=/x", "https://a.com/q?flag"}, "https://a.com/")
+	for _, want := range []string{"https://a.com/p?redirect=/x", "https://a.com/q?flag"} {
+		if !links.Contains(want) {
+			t.Errorf("links %v don't contain %s", links.Sorted(), want)
+		}
+	}
+	for _, link := range links.Sorted() {
+		if reason := s.invalidURLReason(link); reason != "" {
+			t.Errorf("enqueued link %s is invalid when popped: %s", link, reason)
+		}
+	}
+}
+
+func TestRenameStoredPages(t *testing.T) {
+	s := newTestSpider(t, nil)
+	page := common.NewWebPage(1, "http://A.com/p/?b=2&a=1", "200 OK", "<p>old</p>")
+	oldName := filepath.Join(s.workingDirectory, strconv.FormatUint(s.hash(page.Url), 10)+common.PageExt)
+	err := os.WriteFile(oldName, page.Serialize(), 0666)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.pageDownloaded(page.Url) {
+		t.Fatal("page stored under its raw URL counts as downloaded before renaming")
+	}
+	err = s.RenameStoredPages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !s.pageDownloaded(page.Url) {
+		t.Error("page isn't downloaded after renaming")
+	}
+	if _, err := os.Stat(oldName); !os.IsNotExist(err) {
+		t.Errorf("old file still exists: %v", err)
+	}
+}
+
+func TestRoutineStatusBeforeCrawl(t *testing.T) {
+	s := newTestSpider(t, func(config *Config) { config.NumRoutines = 3 })
+	status := s.RoutineStatus()
+	if len(status) != 3 {
+		t.Fatalf("got %d routines, want 3", len(status))
+	}
+	for _, info := range status {
+		if info.Url != "" {
+			t.Errorf("routine %d is working on %s before the crawl", info.Routine, info.Url)
+		}
+	}
+}
+
+func TestBloomFPRateValidated(t *testing.T) {
+	for _, rate := range []float64{0, 1, 1.5, -0.1, math.NaN()} {
+		s := &SearchHouseSpider{}
+		if err := s.setDownloadedBloomFilter(1000, rate); err == nil {
+			t.Errorf("rate %v accepted", rate)
+		}
+	}
+}
+
+func TestSampleRateValidated(t *testing.T) {
+	s := &SearchHouseSpider{}
+	for _, rate := range []float64{0, 0.5, 1} {
+		if err := s.setSampleRate(rate); err != nil {
+			t.Errorf("rate %v rejected: %v", rate, err)
+		}
+	}
+	for _, rate := range []float64{1.5, -0.1, math.NaN(), math.Inf(1), math.Inf(-1)} {
+		if err := s.setSampleRate(rate); err == nil {
+			t.Errorf("rate %v accepted", rate)
+		}
+	}
+}
+
+func TestConfigJSONLeavesOutHostOverrides(t *testing.T) {
+	config := DefaultConfig()
+	config.HostUserAgents = map[string]string{"a.com": "secret-agent"}
+	config.HostOverrides = map[string]string{"a.com": "10.0.0.1"}
+	config.HostHeader = "global-vhost"
+	config.HostHeaders = map[string]string{"a.com": "vhost"}
+	b, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"secret-agent", "10.0.0.1", "global-vhost", "vhost"} {
+		if strings.Contains(string(b), secret) {
+			t.Errorf("config JSON contains %q: %s", secret, b)
+		}
+	}
+}
+
+func TestConstructProperURLsEscapedPaths(t *testing.T) {
+	s := newTestSpider(t, nil)
+	links := s.constructProperURLs([]string{"/café", "/caf%c3%a9", "https://a.com/caf%C3%A9/", "/p?b=2&a=1"}, "https://a.com/")
+	want := []string{"https://a.com/caf%C3%A9", "https://a.com/p?a=1&b=2"}
+	if got := links.Sorted(); !slices.Equal(got, want) {
+		t.Errorf("got links %v, want %v", got, want)
+	}
+}
+
+func TestTrailingDotHosts(t *testing.T) {
+	s := newTestSpider(t, func(config *Config) {
+		config.NumRoutines = 7
+		config.SameHostAsSeed = true
+	})
+	s.setSeed([]string{"https://example.com./"})
+	if reason := s.invalidURLReason("https://example.com/p"); reason != "" {
+		t.Errorf("https://example.com/p rejected: %s", reason)
+	}
+	if reason := s.invalidURLReason("https://example.com./p"); reason != "" {
+		t.Errorf("https://example.com./p rejected: %s", reason)
+	}
+	if got := s.getHostname("https://example.com./p"); got != "example.com" {
+		t.Errorf("got hostname %q, want example.com", got)
+	}
+	if got, want := s.hash(s.findHostName("https://example.com./p")), s.hash("https://example.com"); got != want {
+		t.Errorf("trailing dot host hashes to %d, want %d", got, want)
+	}
+	if got, want := s.calcWebsiteToRoutineNum("https://example.com./p"), s.calcWebsiteToRoutineNum("https://example.com/q"); got != want {
+		t.Errorf("trailing dot host assigned to routine %d, want %d", got, want)
+	}
+}
+
+func TestInvalidURLReasonExtensions(t *testing.T) {
+	s := newTestSpider(t, nil)
+	tests := []struct {
+		url  string
+		want string
+	}{
+		{"https://a.com/report.pdf", "unwanted extension"},
+		{"https://a.com/Report.PDF", "unwanted extension"},
+		{"https://a.com/view?file=report.pdf", ""},
+		{"https://a.com/view?img=a.png&next=b.js", ""},
+		{"https://a.com/report.pdf?download=1", "unwanted extension"},
+		{"https://a.com/v1.2/notes", ""},
+		{"https://a.com/2024.01.05/post", ""},
+		{"https://blog.a.co.uk/a.b/c", ""},
+		{"https://a.com/p?q=a.b.c", ""},
+		{"https://a/p", "malformed URL"},
+		{"http://a.com/p", "malformed URL"},
+	}
+	for _, test := range tests {
+		if got := s.invalidURLReason(test.url); got != test.want {
+			t.Errorf("invalidURLReason(%q) = %q, want %q", test.url, got, test.want)
+		}
+	}
+}
+
+func stubRetrySleep(t *testing.T, sleep func(d time.Duration)) {
+	t.Helper()
+	sleepBeforeRetry = sleep
+	t.Cleanup(func() { sleepBeforeRetry = time.Sleep })
+}
+
+func TestWriteWithRetryRecovers(t *testing.T) {
+	pageDir := filepath.Join(t.TempDir(), "pages")
+	s := newTestSpider(t, func(config *Config) {
+		config.PageDir = pageDir
+	})
+	// The page directory is gone on the first attempt
+	// and recreated during the backoff
+	var sleeps []time.Duration
+	stubRetrySleep(t, func(d time.Duration) {
+		sleeps = append(sleeps, d)
+		os.Mkdir(pageDir, 0777)
+	})
+	page := common.WebPage{Url: "https://a.com/p", Body: "<p>a</p>"}
+	err := s.writeWithRetry(page)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(sleeps, []time.Duration{time.Second}) {
+		t.Errorf("got backoffs %v, want one of 1s", sleeps)
+	}
+	if _, err := os.Stat(s.pageFileName(page.Url)); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWriteWithRetryGivesUp(t *testing.T) {
+	pageDir := filepath.Join(t.TempDir(), "pages")
+	s := newTestSpider(t, func(config *Config) {
+		config.PageDir = pageDir
+	})
+	var sleeps int
+	stubRetrySleep(t, func(time.Duration) { sleeps++ })
+	err := s.writeWithRetry(common.WebPage{Url: "https://a.com/p"})
+	if err == nil {
+		t.Fatal("write to a missing directory succeeded")
+	}
+	// No backoff after the last attempt
+	if sleeps != writeRetries {
+		t.Errorf("slept %d times, want %d", sleeps, writeRetries)
+	}
+}
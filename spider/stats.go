@@ -0,0 +1,139 @@
+package spider
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"sync"
+)
+
+// Categories a failed fetch is classified into
+const (
+	FailureDNS        = "dns"
+	FailureConnection = "connection"
+	FailureTLS        = "tls"
+	FailureTimeout    = "timeout"
+	FailureHTTP4xx    = "http-4xx"
+	FailureHTTP5xx    = "http-5xx"
+	FailureBlocked    = "blocked"
+	FailureTooLarge   = "too-large"
+	FailureOther      = "other"
+)
+
+// CrawlStats holds counters describing the progress of a
+// crawl, safe to update from multiple routines
+
+type CrawlStats struct {
+	mu          *sync.Mutex
+	PagesStored int64            `json:"pagesStored"`
+	NewURLs     int64            `json:"newUrls"`
+	KnownURLs   int64            `json:"knownUrls"`
+	Bytes       int64            `json:"bytesDownloaded"`
+	Decoded     int64            `json:"bytesDecompressed"`
+	Failures    map[string]int64 `json:"failures"`
+}
+
+func NewCrawlStats() *CrawlStats {
+	return &CrawlStats{mu: new(sync.Mutex), Failures: make(map[string]int64)}
+}
+
+func (cs *CrawlStats) RecordStored() {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.PagesStored++
+}
+
+func (cs *CrawlStats) RecordDiscovered(isNew bool) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	if isNew {
+		cs.NewURLs++
+	} else {
+		cs.KnownURLs++
+	}
+}
+
+func (cs *CrawlStats) RecordBytes(received int64, decompressed int64) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.Bytes += received
+	cs.Decoded += decompressed
+}
+
+func (cs *CrawlStats) RecordFailure(category string) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.Failures[category]++
+}
+
+func (cs *CrawlStats) Snapshot() CrawlStats {
+	// Copy the counters so they can be read without
+	// racing against routines still updating them
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	failures := make(map[string]int64, len(cs.Failures))
+	for category, count := range cs.Failures {
+		failures[category] = count
+	}
+	return CrawlStats{
+		mu:          new(sync.Mutex),
+		PagesStored: cs.PagesStored,
+		NewURLs:     cs.NewURLs,
+		KnownURLs:   cs.KnownURLs,
+		Bytes:       cs.Bytes,
+		Decoded:     cs.Decoded,
+		Failures:    failures,
+	}
+}
+
+func (cs *CrawlStats) restore(saved CrawlStats) {
+	// Add the counters of a previous run to this one's
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+	cs.PagesStored += saved.PagesStored
+	cs.NewURLs += saved.NewURLs
+	cs.KnownURLs += saved.KnownURLs
+	cs.Bytes += saved.Bytes
+	cs.Decoded += saved.Decoded
+	for category, count := range saved.Failures {
+		cs.Failures[category] += count
+	}
+}
+
+func classifyFetchError(err error) string {
+	var dnsErr *net.DNSError
+	var certErr *tls.CertificateVerificationError
+	var recordErr tls.RecordHeaderError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var invalidErr x509.CertificateInvalidError
+	var netErr net.Error
+	var opErr *net.OpError
+	switch {
+	case errors.Is(err, errPrivateAddress):
+		return FailureBlocked
+	case errors.As(err, &dnsErr):
+		return FailureDNS
+	case errors.As(err, &certErr), errors.As(err, &recordErr), errors.As(err, &authorityErr),
+		errors.As(err, &hostnameErr), errors.As(err, &invalidErr):
+		return FailureTLS
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return FailureTimeout
+	case errors.As(err, &opErr):
+		return FailureConnection
+	default:
+		return FailureOther
+	}
+}
+
+func classifyStatus(statusCode int) string {
+	switch {
+	case statusCode >= 400 && statusCode < 500:
+		return FailureHTTP4xx
+	case statusCode >= 500 && statusCode < 600:
+		return FailureHTTP5xx
+	default:
+		return FailureOther
+	}
+}
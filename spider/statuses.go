@@ -0,0 +1,69 @@
+package spider
+
+import (
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const non200FileName = "non200.jsonl"
+
+// non200Response is a line of non200.jsonl, a URL that
+// answered with a status other than 200 OK and no page
+
+type non200Response struct {
+	Url       string `json:"url"`
+	Status    int    `json:"status"`
+	Timestamp int64  `json:"timestamp"`
+}
+
+// non200Log appends every non-200 response to non200.jsonl
+// in the working directory, apart from the stored pages
+
+type non200Log struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+func (s *SearchHouseSpider) setRecordNon200(enabled bool) {
+	// Record the URL and status of every response other than
+	// 200 OK, e.g. to audit a site for broken links
+	s.recordNon200 = enabled
+}
+
+func (s *SearchHouseSpider) openNon200Log() error {
+	if !s.recordNon200 {
+		return nil
+	}
+	f, err := os.OpenFile(filepath.Join(s.workingDirectory, non200FileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+	if err != nil {
+		return err
+	}
+	s.non200 = &non200Log{f: f}
+	return nil
+}
+
+func (nl *non200Log) record(url string, status int) {
+	if nl == nil {
+		return
+	}
+	line, _ := json.Marshal(non200Response{Url: url, Status: status, Timestamp: time.Now().Unix()})
+	nl.mu.Lock()
+	defer nl.mu.Unlock()
+	_, err := nl.f.Write(append(line, '\n'))
+	if err != nil {
+		slog.Error("spider - Error recording non-200 response", "err", err)
+	}
+}
+
+func (nl *non200Log) close() {
+	if nl == nil {
+		return
+	}
+	nl.mu.Lock()
+	defer nl.mu.Unlock()
+	nl.f.Close()
+}
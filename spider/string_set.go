@@ -1,5 +1,7 @@
 package spider
 
+import "sort"
+
 type StringSet struct {
 	m map[string]bool
 }
@@ -24,3 +26,12 @@ func (s *StringSet) Merge(right StringSet) {
 		s.Add(key)
 	}
 }
+
+func (s *StringSet) Sorted() []string {
+	sorted := make([]string, 0, len(s.m))
+	for key := range s.m {
+		sorted = append(sorted, key)
+	}
+	sort.Strings(sorted)
+	return sorted
+}
@@ -0,0 +1,48 @@
+package spider
+
+import (
+	"context"
+	"crypto/tls"
+	"log/slog"
+	"net/http/httptrace"
+	"time"
+)
+
+// fetchTimings records how long each phase of a
+// request took using an httptrace.ClientTrace
+
+type fetchTimings struct {
+	start        time.Time
+	dnsStart     time.Time
+	dns          time.Duration
+	connectStart time.Time
+	connect      time.Duration
+	tlsStart     time.Time
+	tls          time.Duration
+	ttfb         time.Duration
+}
+
+func withFetchTimings(ctx context.Context, ft *fetchTimings) context.Context {
+	ft.start = time.Now()
+	return httptrace.WithClientTrace(ctx, &httptrace.ClientTrace{
+		DNSStart: func(httptrace.DNSStartInfo) { ft.dnsStart = time.Now() },
+		DNSDone:  func(httptrace.DNSDoneInfo) { ft.dns = time.Since(ft.dnsStart) },
+		ConnectStart: func(string, string) {
+			ft.connectStart = time.Now()
+		},
+		ConnectDone: func(string, string, error) {
+			ft.connect = time.Since(ft.connectStart)
+		},
+		TLSHandshakeStart: func() { ft.tlsStart = time.Now() },
+		TLSHandshakeDone: func(tls.ConnectionState, error) {
+			ft.tls = time.Since(ft.tlsStart)
+		},
+		GotFirstResponseByte: func() { ft.ttfb = time.Since(ft.start) },
+	})
+}
+
+func (ft *fetchTimings) log(url string) {
+	// Phases skipped thanks to a reused connection are logged as 0s
+	slog.Debug("spider - Timings", "url", url, "dns", ft.dns, "connect", ft.connect,
+		"tls", ft.tls, "ttfb", ft.ttfb, "total", time.Since(ft.start))
+}
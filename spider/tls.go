@@ -0,0 +1,69 @@
+package spider
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+func (s *SearchHouseSpider) setTLS(minVersion string, pins map[string]string) error {
+	// Refuse TLS versions older than minVersion ("" keeps the
+	// default) and pin hosts to the hex SHA-256 fingerprint of
+	// their leaf certificate, aborting requests on a mismatch.
+	// Pins match the TLS server name so hosts must be names
+	// rather than IP addresses, which don't send one
+	config := s.transport.TLSClientConfig
+	if config == nil {
+		config = &tls.Config{}
+	}
+	if minVersion != "" {
+		version, exists := tlsVersions[minVersion]
+		if !exists {
+			return fmt.Errorf("unknown TLS version %q", minVersion)
+		}
+		config.MinVersion = version
+	}
+	if len(pins) > 0 {
+		fingerprints := make(map[string][]byte, len(pins))
+		for host, fingerprint := range pins {
+			b, err := hex.DecodeString(strings.ReplaceAll(fingerprint, ":", ""))
+			if err != nil || len(b) != sha256.Size {
+				return fmt.Errorf("invalid SHA-256 fingerprint %q for %s", fingerprint, host)
+			}
+			fingerprints[strings.ToLower(host)] = b
+		}
+		// VerifyConnection runs after the usual chain verification
+		// and, unlike VerifyPeerCertificate, knows the host dialed
+		config.VerifyConnection = func(cs tls.ConnectionState) error {
+			return verifyPin(fingerprints, cs)
+		}
+	}
+	s.transport.TLSClientConfig = config
+	return nil
+}
+
+func verifyPin(fingerprints map[string][]byte, cs tls.ConnectionState) error {
+	want, pinned := fingerprints[strings.ToLower(cs.ServerName)]
+	if !pinned {
+		return nil
+	}
+	if len(cs.PeerCertificates) == 0 {
+		return fmt.Errorf("no certificate presented by pinned host %s", cs.ServerName)
+	}
+	got := sha256.Sum256(cs.PeerCertificates[0].Raw)
+	if string(got[:]) != string(want) {
+		slog.Error("spider - Certificate doesn't match its pin", "host", cs.ServerName, "got", fmt.Sprintf("%x", got))
+		return fmt.Errorf("certificate of %s doesn't match its pin", cs.ServerName)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package spider
+
+import (
+	"hash/fnv"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"sync"
+)
+
+// Path segments that commonly produce an effectively infinite
+// amount of unique URLs on WordPress sites (deep pagination
+// and date archives)
+var DefaultTrapPatterns = []string{
+	`/page/\d+`,
+	`/\d{4}/\d{2}(/\d{2})?`,
+}
+
+// TrapDetector counts how many variants of a templated
+// path (e.g. /page/N/) have been seen for each host and
+// rejects further variants once a threshold is exceeded.
+
+type TrapDetector struct {
+	mu        sync.Mutex
+	patterns  []*regexp.Regexp
+	threshold int
+	variants  map[string]map[uint64]struct{}
+}
+
+func NewTrapDetector(patterns []string, threshold int) (*TrapDetector, error) {
+	compiled := make([]*regexp.Regexp, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, re)
+	}
+	return &TrapDetector{
+		patterns:  compiled,
+		threshold: threshold,
+		variants:  make(map[string]map[uint64]struct{}),
+	}, nil
+}
+
+func (td *TrapDetector) Trapped(rawUrl string) bool {
+	// Record the URL as a variant of each templated path it
+	// matches, returning true if any template has already
+	// exceeded the threshold for this host
+	parsedUrl, err := url.Parse(rawUrl)
+	if err != nil {
+		return false
+	}
+	td.mu.Lock()
+	defer td.mu.Unlock()
+	for _, re := range td.patterns {
+		if !re.MatchString(parsedUrl.Path) {
+			continue
+		}
+		template := parsedUrl.Host + re.ReplaceAllString(parsedUrl.Path, "/{"+re.String()+"}")
+		seen, exists := td.variants[template]
+		if !exists {
+			seen = make(map[uint64]struct{})
+			td.variants[template] = seen
+		}
+		h := td.hash(parsedUrl.Path)
+		if _, exists := seen[h]; exists {
+			continue
+		}
+		if len(seen) >= td.threshold {
+			if len(seen) == td.threshold {
+				slog.Info("spider - Crawl trap detected, no longer enqueuing variants", "template", template)
+				// Bump past the threshold so the trap is only logged once
+				seen[h] = struct{}{}
+			}
+			return true
+		}
+		seen[h] = struct{}{}
+	}
+	return false
+}
+
+func (td *TrapDetector) hash(str string) uint64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(str))
+	return h.Sum64()
+}